@@ -0,0 +1,449 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package httpstat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AlertState is the state of an alert, as tracked by an Alerter.
+type AlertState int
+
+const (
+	// AlertInactive means the rule has never breached its threshold, or has
+	// been resolved and re-armed.
+	AlertInactive AlertState = iota
+	// AlertPending means the rule is breaching its threshold, but has not
+	// done so for AlertRule.For intervals yet.
+	AlertPending
+	// AlertFiring means the rule has breached its threshold for at least
+	// AlertRule.For intervals.
+	AlertFiring
+	// AlertResolved means the rule was firing, and has since recovered. On the
+	// next snapshot which doesn't breach the threshold, it's re-armed and
+	// becomes inactive again.
+	AlertResolved
+)
+
+// String returns the lowercase name of the state (e.g. "firing").
+func (s AlertState) String() string {
+	switch s {
+	case AlertPending:
+		return "pending"
+	case AlertFiring:
+		return "firing"
+	case AlertResolved:
+		return "resolved"
+	default:
+		return "inactive"
+	}
+}
+
+// MarshalText implements the encoding.TextMarshaler interface.
+func (s AlertState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// AlertMetric computes the value an AlertRule is compared against, from the
+// currently stored history (e.g. using History.Last or History.Range). If
+// there isn't enough history to compute the value, ok should be false, in
+// which case the rule is left as-is.
+type AlertMetric func(h *History) (value float64, ok bool)
+
+// ErrorRate returns an AlertMetric which is the percentage (0-100) of
+// requests in the latest snapshot interval which resulted in an error.
+func ErrorRate() AlertMetric {
+	return func(h *History) (float64, bool) {
+		cur, ok := h.Last()
+		if !ok {
+			return 0, false
+		}
+
+		if cur.RequestsDiff == 0 {
+			return 0, true
+		}
+
+		return float64(cur.ErrorsDiff) / float64(cur.RequestsDiff) * 100, true
+	}
+}
+
+// MeanLatency returns an AlertMetric which is the mean request latency of the
+// latest snapshot interval, in seconds.
+func MeanLatency() AlertMetric {
+	return func(h *History) (float64, bool) {
+		cur, ok := h.Last()
+		return cur.LatencyMean, ok
+	}
+}
+
+// LatencyPercentile returns an AlertMetric which is the estimated latency
+// percentile of the latest snapshot interval, in seconds. p must be one of
+// 50, 90 or 99.
+func LatencyPercentile(p int) AlertMetric {
+	var field func(e *HistoryElem) float64
+	switch p {
+	case 50:
+		field = func(e *HistoryElem) float64 { return e.LatencyP50 }
+	case 90:
+		field = func(e *HistoryElem) float64 { return e.LatencyP90 }
+	case 99:
+		field = func(e *HistoryElem) float64 { return e.LatencyP99 }
+	default:
+		panic(fmt.Sprintf("httpstat: unsupported latency percentile %d", p))
+	}
+
+	return func(h *History) (float64, bool) {
+		cur, ok := h.Last()
+		return field(&cur), ok
+	}
+}
+
+// RequestRate returns an AlertMetric which is the requests per second of the
+// latest snapshot interval.
+func RequestRate() AlertMetric {
+	return func(h *History) (float64, bool) {
+		cur, ok := h.Last()
+		return cur.RPS, ok
+	}
+}
+
+// AlertRule describes when an alert should fire, and when it should resolve.
+// For example, "error rate above 5% for 3 intervals" would be:
+//
+//	AlertRule{Name: "errors", Metric: ErrorRate(), Threshold: 5, For: 3}
+type AlertRule struct {
+	// Name uniquely identifies the rule, and is included in notifications.
+	Name string
+	// Metric is the value compared against Threshold on each snapshot.
+	Metric AlertMetric
+	// Threshold is the value Metric must exceed (or fall under, if Below is
+	// true) to be considered breaching.
+	Threshold float64
+	// Below inverts the comparison, so that values under Threshold are
+	// considered breaching (e.g. for a minimum request rate).
+	Below bool
+	// For is the amount of consecutive breaching snapshots required before
+	// the alert transitions from pending to firing. Values less than 1 fire
+	// on the first breaching snapshot.
+	For int
+	// ResolveThreshold adds hysteresis, and is the value Metric must recover
+	// past before a firing alert is considered recovered. Defaults to
+	// Threshold if nil.
+	ResolveThreshold *float64
+	// ResolveFor is the amount of consecutive recovered snapshots required
+	// before a firing alert is resolved. Values less than 1 resolve on the
+	// first recovered snapshot.
+	ResolveFor int
+}
+
+func (r *AlertRule) breaching(v float64) bool {
+	if r.Below {
+		return v < r.Threshold
+	}
+	return v > r.Threshold
+}
+
+func (r *AlertRule) recovered(v float64) bool {
+	threshold := r.Threshold
+	if r.ResolveThreshold != nil {
+		threshold = *r.ResolveThreshold
+	}
+
+	if r.Below {
+		return v >= threshold
+	}
+	return v <= threshold
+}
+
+// Alert is the current state of an AlertRule.
+type Alert struct {
+	Rule      string     `json:"rule"`
+	State     AlertState `json:"state"`
+	Value     float64    `json:"value"`
+	Threshold float64    `json:"threshold"`
+	// Since is when the alert entered its current state.
+	Since time.Time `json:"since"`
+	// FiredAt is when the alert last transitioned to firing, if ever.
+	FiredAt time.Time `json:"fired_at,omitempty"`
+}
+
+// Notifier is sent alerts when they transition to firing, and to resolved.
+type Notifier interface {
+	Notify(alert Alert) error
+}
+
+// NotifierFunc is an adapter to allow the use of ordinary functions as a
+// Notifier.
+type NotifierFunc func(alert Alert) error
+
+// Notify calls fn(alert).
+func (fn NotifierFunc) Notify(alert Alert) error {
+	return fn(alert)
+}
+
+// LogNotifier is a Notifier which writes a line to Logger for each alert.
+type LogNotifier struct {
+	// Logger is the logger to write to. If nil, the log package's standard
+	// logger is used.
+	Logger *log.Logger
+}
+
+// Notify implements the Notifier interface.
+func (n *LogNotifier) Notify(alert Alert) error {
+	msg := fmt.Sprintf("httpstat: alert %q is %s (value: %g, threshold: %g)", alert.Rule, alert.State, alert.Value, alert.Threshold)
+
+	if n.Logger == nil {
+		log.Print(msg)
+		return nil
+	}
+
+	n.Logger.Print(msg)
+	return nil
+}
+
+// WebhookNotifier is a Notifier which POSTs each alert as JSON to URL.
+type WebhookNotifier struct {
+	URL string
+	// Header are additional headers to include in the request (e.g. for
+	// authentication).
+	Header http.Header
+	// Client is the http client used to send the request. If nil, a client
+	// with a 10 second timeout is used.
+	Client *http.Client
+}
+
+// Notify implements the Notifier interface.
+func (n *WebhookNotifier) Notify(alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	for key, values := range n.Header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := n.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Drained, so the connection can be reused.
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s returned status %d", n.URL, resp.StatusCode)
+	}
+
+	return nil
+}
+
+// alertNotifier queues alerts for a Notifier, so they're delivered in order.
+type alertNotifier struct {
+	n       Notifier
+	mu      sync.Mutex
+	queue   []Alert
+	running bool
+}
+
+type alertEntry struct {
+	rule      AlertRule
+	alert     Alert
+	breaches  int
+	recovered int
+}
+
+// Alerter evaluates a set of AlertRules against every History snapshot, and
+// sends state changes to the registered Notifiers. An Alerter is available
+// via HTTPStats.Alerts, and requires History to be enabled.
+type Alerter struct {
+	// ErrorLog is used to log errors returned from Notifiers. If nil, the log
+	// package's standard logger is used.
+	ErrorLog *log.Logger
+
+	mu        sync.RWMutex
+	entries   []*alertEntry
+	notifiers []*alertNotifier
+}
+
+// AddRule registers a new rule to be evaluated on each snapshot.
+func (a *Alerter) AddRule(rule AlertRule) {
+	a.mu.Lock()
+	a.entries = append(a.entries, &alertEntry{
+		rule:  rule,
+		alert: Alert{Rule: rule.Name, Threshold: rule.Threshold},
+	})
+	a.mu.Unlock()
+}
+
+// AddNotifier registers a Notifier, which will be sent alerts that change
+// state to firing or resolved. Each Notifier is invoked from its own
+// goroutine, one alert at a time, in the order the alerts changed state.
+func (a *Alerter) AddNotifier(n Notifier) {
+	a.mu.Lock()
+	a.notifiers = append(a.notifiers, &alertNotifier{n: n})
+	a.mu.Unlock()
+}
+
+// Alerts returns the current state of all registered rules.
+func (a *Alerter) Alerts() []Alert {
+	a.mu.RLock()
+	alerts := make([]Alert, 0, len(a.entries))
+	for _, entry := range a.entries {
+		alerts = append(alerts, entry.alert)
+	}
+	a.mu.RUnlock()
+
+	return alerts
+}
+
+// Active returns all alerts which are currently pending or firing.
+func (a *Alerter) Active() []Alert {
+	a.mu.RLock()
+	var alerts []Alert
+	for _, entry := range a.entries {
+		if entry.alert.State == AlertPending || entry.alert.State == AlertFiring {
+			alerts = append(alerts, entry.alert)
+		}
+	}
+	a.mu.RUnlock()
+
+	return alerts
+}
+
+func (a *Alerter) evaluate(h *History, now time.Time) {
+	var changed []Alert
+
+	a.mu.Lock()
+	if len(a.entries) == 0 {
+		a.mu.Unlock()
+		return
+	}
+
+	for _, entry := range a.entries {
+		if entry.rule.Metric == nil {
+			continue
+		}
+
+		value, ok := entry.rule.Metric(h)
+		if !ok {
+			continue
+		}
+
+		entry.alert.Value = value
+		prev := entry.alert.State
+
+		switch prev {
+		case AlertInactive, AlertResolved, AlertPending:
+			if !entry.rule.breaching(value) {
+				entry.breaches = 0
+				if prev != AlertInactive {
+					entry.setState(AlertInactive, now)
+				}
+				break
+			}
+
+			entry.breaches++
+			if entry.breaches >= entry.rule.For {
+				entry.recovered = 0
+				entry.alert.FiredAt = now
+				entry.setState(AlertFiring, now)
+			} else if prev != AlertPending {
+				entry.setState(AlertPending, now)
+			}
+		case AlertFiring:
+			if !entry.rule.recovered(value) {
+				entry.recovered = 0
+				break
+			}
+
+			entry.recovered++
+			if entry.recovered >= entry.rule.ResolveFor {
+				entry.breaches = 0
+				entry.setState(AlertResolved, now)
+			}
+		}
+
+		if entry.alert.State != prev && (entry.alert.State == AlertFiring || entry.alert.State == AlertResolved) {
+			changed = append(changed, entry.alert)
+		}
+	}
+	notifiers := a.notifiers
+	a.mu.Unlock()
+
+	for _, n := range notifiers {
+		a.send(n, changed...)
+	}
+}
+
+// send queues alerts for n, starting a goroutine to deliver them if there
+// isn't one already. The goroutine returns once the queue is empty.
+func (a *Alerter) send(n *alertNotifier, alerts ...Alert) {
+	if len(alerts) == 0 {
+		return
+	}
+
+	n.mu.Lock()
+	n.queue = append(n.queue, alerts...)
+	if n.running {
+		n.mu.Unlock()
+		return
+	}
+	n.running = true
+	n.mu.Unlock()
+
+	go func() {
+		for {
+			n.mu.Lock()
+			if len(n.queue) == 0 {
+				n.running = false
+				n.mu.Unlock()
+				return
+			}
+			alert := n.queue[0]
+			n.queue = n.queue[1:]
+			n.mu.Unlock()
+
+			a.notify(n.n, alert)
+		}
+	}()
+}
+
+func (e *alertEntry) setState(state AlertState, now time.Time) {
+	e.alert.State = state
+	e.alert.Since = now
+}
+
+func (a *Alerter) notify(n Notifier, alert Alert) {
+	err := n.Notify(alert)
+	if err == nil {
+		return
+	}
+
+	if a.ErrorLog != nil {
+		a.ErrorLog.Printf("httpstat: error notifying alert %q: %s", alert.Rule, err)
+		return
+	}
+	log.Printf("httpstat: error notifying alert %q: %s", alert.Rule, err)
+}
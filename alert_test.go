@@ -0,0 +1,115 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package httpstat
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAlerterStates(t *testing.T) {
+	var value float64
+	metric := func(h *History) (float64, bool) { return value, true }
+
+	resolveAt := 3.0
+	a := &Alerter{}
+	a.AddRule(AlertRule{Name: "test", Metric: metric, Threshold: 5, For: 2, ResolveThreshold: &resolveAt})
+
+	notified := make(chan Alert, 10)
+	a.AddNotifier(NotifierFunc(func(alert Alert) error {
+		// A slow first delivery shouldn't let the next one overtake it.
+		if alert.State == AlertFiring {
+			time.Sleep(10 * time.Millisecond)
+		}
+		notified <- alert
+		return nil
+	}))
+
+	steps := []struct {
+		value float64
+		want  AlertState
+	}{
+		{1, AlertInactive},
+		{6, AlertPending},
+		{2, AlertInactive},
+		{6, AlertPending},
+		{7, AlertFiring},
+		{4, AlertFiring}, // Hysteresis, still above ResolveThreshold.
+		{2, AlertResolved},
+		{1, AlertInactive}, // Re-armed.
+		{6, AlertPending},
+	}
+
+	for i, step := range steps {
+		value = step.value
+		a.evaluate(nil, time.Now())
+
+		if got := a.Alerts()[0].State; got != step.want {
+			t.Fatalf("step %d: got state %s, want %s", i, got, step.want)
+		}
+	}
+
+	// Notifiers are invoked asynchronously, but in order.
+	for _, want := range []AlertState{AlertFiring, AlertResolved} {
+		select {
+		case alert := <-notified:
+			if alert.State != want {
+				t.Fatalf("expected %s notification, got %s", want, alert.State)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for notifications")
+		}
+	}
+
+	if active := a.Active(); len(active) != 1 || active[0].State != AlertPending {
+		t.Fatalf("unexpected active alerts: %+v", active)
+	}
+}
+
+func TestAlerterResolveAtZero(t *testing.T) {
+	var value float64
+	metric := func(h *History) (float64, bool) { return value, true }
+
+	resolveAt := 0.0
+	a := &Alerter{}
+	a.AddRule(AlertRule{Name: "test", Metric: metric, Threshold: 5, ResolveThreshold: &resolveAt})
+
+	for i, step := range []struct {
+		value float64
+		want  AlertState
+	}{
+		{6, AlertFiring},
+		{1, AlertFiring}, // Below Threshold, but not yet at zero.
+		{0, AlertResolved},
+	} {
+		value = step.value
+		a.evaluate(nil, time.Now())
+
+		if got := a.Alerts()[0].State; got != step.want {
+			t.Fatalf("step %d: got state %s, want %s", i, got, step.want)
+		}
+	}
+}
+
+func TestAlertMetrics(t *testing.T) {
+	stats := New("alert_"+strconv.Itoa(time.Now().Nanosecond()), &HistoryOptions{Enabled: true, Resolution: time.Hour})
+	defer stats.Close()
+
+	if _, ok := ErrorRate()(&stats.History); ok {
+		t.Fatal("expected no value without any snapshots")
+	}
+
+	stats.RequestsTotal.Add(4)
+	stats.RequestErrorsTotal.Add(1)
+	stats.History.add(stats)
+
+	if value, ok := ErrorRate()(&stats.History); !ok || value != 25 {
+		t.Fatalf("expected error rate of 25, got %g (%v)", value, ok)
+	}
+	if value, ok := RequestRate()(&stats.History); !ok || value <= 0 {
+		t.Fatalf("expected positive request rate, got %g (%v)", value, ok)
+	}
+}
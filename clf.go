@@ -0,0 +1,220 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package httpstat
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Apache log formats which can be used with NewApacheLogger.
+const (
+	CommonLogFormat   = `%h %l %u %t "%r" %>s %b`
+	CombinedLogFormat = `%h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-Agent}i"`
+)
+
+type clfDirective func(buf *bytes.Buffer, e *RequestEvent)
+
+// ApacheLogger writes access logs in Apache mod_log_config style formats.
+type ApacheLogger struct {
+	mu         sync.Mutex
+	w          io.Writer
+	buf        bytes.Buffer
+	directives []clfDirective
+}
+
+// NewApacheLogger returns a new ApacheLogger which writes to w, using the
+// provided format (e.g. CommonLogFormat or CombinedLogFormat). The
+// following directives are supported:
+//
+//	%%          a literal percent sign
+//	%a, %h      the remote address, without the port
+//	%l          always "-"
+//	%u          the basic auth username, or "-"
+//	%t          the request start time, e.g. [10/Oct/2000:13:55:36 -0700]
+//	%r          the request line, e.g. GET /index.html HTTP/1.1
+//	%s, %>s     the response status
+//	%b          the response body size, or "-" if zero
+//	%B, %O      the response body size
+//	%I          the approximate request size
+//	%D          the request duration, in microseconds
+//	%T          the request duration, in seconds
+//	%m          the request method
+//	%U          the request path
+//	%q          the query string, including the "?", or an empty string
+//	%H          the request protocol
+//	%v          the request host
+//	%R          the route key (see HTTPStats.RouteKey), or "-"
+//	%{Name}i    the value of the Name request header, or "-"
+//
+// Register the logger using ApacheLogger.Log, e.g.:
+//
+//	stats.OnRequest(logger.Log)
+func NewApacheLogger(w io.Writer, format string) (*ApacheLogger, error) {
+	l := &ApacheLogger{w: w}
+
+	for i := 0; i < len(format); i++ {
+		start := i
+		for i < len(format) && format[i] != '%' {
+			i++
+		}
+		if i > start {
+			l.directives = append(l.directives, clfLiteral(format[start:i]))
+		}
+		if i >= len(format) {
+			break
+		}
+
+		// Skip the "%", and the ">" modifier (final status), which is the only
+		// status we know of anyway.
+		i++
+		if i < len(format) && format[i] == '>' {
+			i++
+		}
+		if i >= len(format) {
+			return nil, fmt.Errorf("httpstat: invalid log format %q: trailing %%", format)
+		}
+
+		if format[i] == '{' {
+			end := strings.IndexByte(format[i:], '}')
+			if end < 0 || i+end+1 >= len(format) {
+				return nil, fmt.Errorf("httpstat: invalid log format %q: unterminated %%{", format)
+			}
+
+			name := format[i+1 : i+end]
+			i += end + 1
+			if format[i] != 'i' {
+				return nil, fmt.Errorf("httpstat: invalid log format %q: unsupported directive %%{%s}%c", format, name, format[i])
+			}
+
+			l.directives = append(l.directives, clfHeader(http.CanonicalHeaderKey(name)))
+			continue
+		}
+
+		directive, ok := clfDirectives[format[i]]
+		if !ok {
+			return nil, fmt.Errorf("httpstat: invalid log format %q: unsupported directive %%%c", format, format[i])
+		}
+		l.directives = append(l.directives, directive)
+	}
+
+	return l, nil
+}
+
+// Log writes a single log line for the event. Write errors are ignored.
+func (l *ApacheLogger) Log(e RequestEvent) {
+	l.mu.Lock()
+	l.buf.Reset()
+	for _, directive := range l.directives {
+		directive(&l.buf, &e)
+	}
+	l.buf.WriteByte('\n')
+	_, _ = l.w.Write(l.buf.Bytes())
+	l.mu.Unlock()
+}
+
+func clfLiteral(s string) clfDirective {
+	return func(buf *bytes.Buffer, _ *RequestEvent) {
+		buf.WriteString(s)
+	}
+}
+
+func clfHeader(name string) clfDirective {
+	return func(buf *bytes.Buffer, e *RequestEvent) {
+		clfString(buf, strings.Join(e.Header[name], ", "))
+	}
+}
+
+func clfString(buf *bytes.Buffer, s string) {
+	if s == "" {
+		buf.WriteByte('-')
+		return
+	}
+
+	// Escape quotes and control characters, similar to Apache, so values
+	// can't break the log format.
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(buf, "\\x%02x", c)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+}
+
+func remoteHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+func basicAuthUser(header http.Header) string {
+	auth := header.Get("Authorization")
+	if len(auth) < 6 || !strings.EqualFold(auth[:6], "Basic ") {
+		return ""
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(auth[6:])
+	if err != nil {
+		return ""
+	}
+
+	user, _, _ := strings.Cut(string(decoded), ":")
+	return user
+}
+
+var clfDirectives = map[byte]clfDirective{
+	'%': clfLiteral("%"),
+	'a': func(buf *bytes.Buffer, e *RequestEvent) { clfString(buf, remoteHost(e.RemoteAddr)) },
+	'h': func(buf *bytes.Buffer, e *RequestEvent) { clfString(buf, remoteHost(e.RemoteAddr)) },
+	'l': clfLiteral("-"),
+	'u': func(buf *bytes.Buffer, e *RequestEvent) { clfString(buf, basicAuthUser(e.Header)) },
+	't': func(buf *bytes.Buffer, e *RequestEvent) {
+		buf.WriteString(e.Start.Format("[02/Jan/2006:15:04:05 -0700]"))
+	},
+	'r': func(buf *bytes.Buffer, e *RequestEvent) {
+		clfString(buf, e.Method+" "+e.RequestURI+" "+e.Proto)
+	},
+	's': func(buf *bytes.Buffer, e *RequestEvent) { buf.WriteString(strconv.Itoa(e.Status)) },
+	'b': func(buf *bytes.Buffer, e *RequestEvent) {
+		if e.BytesOut == 0 {
+			buf.WriteByte('-')
+			return
+		}
+		buf.WriteString(strconv.Itoa(e.BytesOut))
+	},
+	'B': func(buf *bytes.Buffer, e *RequestEvent) { buf.WriteString(strconv.Itoa(e.BytesOut)) },
+	'O': func(buf *bytes.Buffer, e *RequestEvent) { buf.WriteString(strconv.Itoa(e.BytesOut)) },
+	'I': func(buf *bytes.Buffer, e *RequestEvent) { buf.WriteString(strconv.Itoa(e.BytesIn)) },
+	'D': func(buf *bytes.Buffer, e *RequestEvent) {
+		buf.WriteString(strconv.FormatInt(e.Duration.Microseconds(), 10))
+	},
+	'T': func(buf *bytes.Buffer, e *RequestEvent) {
+		buf.WriteString(strconv.FormatInt(int64(e.Duration.Seconds()), 10))
+	},
+	'm': func(buf *bytes.Buffer, e *RequestEvent) { clfString(buf, e.Method) },
+	'U': func(buf *bytes.Buffer, e *RequestEvent) { clfString(buf, e.Path) },
+	'q': func(buf *bytes.Buffer, e *RequestEvent) {
+		if i := strings.IndexByte(e.RequestURI, '?'); i >= 0 {
+			clfString(buf, e.RequestURI[i:])
+		}
+	},
+	'H': func(buf *bytes.Buffer, e *RequestEvent) { clfString(buf, e.Proto) },
+	'v': func(buf *bytes.Buffer, e *RequestEvent) { clfString(buf, e.Host) },
+	'R': func(buf *bytes.Buffer, e *RequestEvent) { clfString(buf, e.Route) },
+}
@@ -0,0 +1,22 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package httpstat
+
+import "time"
+
+// Clock is the source of time used by HTTPStats, History and UptimeVar. It
+// can be replaced (see HistoryOptions.Clock) to control time in tests.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// After waits for the duration to elapse, and then sends the current
+	// time on the returned channel, like time.After.
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
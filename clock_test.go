@@ -0,0 +1,120 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package httpstat
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock which only moves forward when Advance is called.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+
+	c.waiters = append(c.waiters, fakeWaiter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance moves the clock forward by d, firing any waiters which are due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+
+	waiters := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiters = append(waiters, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = waiters
+}
+
+// waiting blocks until at least n waiters are registered.
+func (c *fakeClock) waiting(t *testing.T, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		count := len(c.waiters)
+		c.mu.Unlock()
+
+		if count >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d waiters", n)
+}
+
+func TestAlignedSnapshots(t *testing.T) {
+	clock := newFakeClock(time.Date(2020, 1, 1, 12, 0, 3, 0, time.UTC))
+
+	stats := New("clock_"+strconv.Itoa(time.Now().Nanosecond()), &HistoryOptions{
+		Enabled:        true,
+		Resolution:     5 * time.Second,
+		AlignSnapshots: true,
+		Clock:          clock,
+	})
+	defer stats.Close()
+
+	snapshots := make(chan HistoryElem, 10)
+	stats.History.OnSnapshot(func(elem HistoryElem) { snapshots <- elem })
+
+	want := []time.Time{
+		time.Date(2020, 1, 1, 12, 0, 5, 0, time.UTC),
+		time.Date(2020, 1, 1, 12, 0, 10, 0, time.UTC),
+	}
+
+	for i := range want {
+		clock.waiting(t, 1)
+		clock.Advance(want[i].Sub(clock.Now()))
+
+		select {
+		case elem := <-snapshots:
+			if !elem.Born.Equal(want[i]) {
+				t.Fatalf("snapshot %d: expected born at %s, got %s", i, want[i], elem.Born)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for snapshot %d", i)
+		}
+	}
+
+	if uptime := stats.Uptime.String(); uptime != "7" {
+		t.Fatalf("expected uptime of 7 seconds, got %s", uptime)
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package httpstat
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RequestEvent holds the information httpstat has recorded about a single
+// request, and is sent to the hooks registered with HTTPStats.OnRequest and
+// HTTPStats.OnRequestAsync.
+type RequestEvent struct {
+	Method     string
+	Path       string
+	RequestURI string
+	Proto      string
+	Host       string
+	// Route is the result of HTTPStats.RouteKey, if it is set.
+	Route    string
+	Status   int
+	Duration time.Duration
+	// BytesIn is the approximate size of the whole request, including the
+	// request line and headers.
+	BytesIn int
+	// ContentLength is the size of the request body, or -1 if unknown (see
+	// http.Request.ContentLength).
+	ContentLength int64
+	BytesOut      int
+	RemoteAddr    string
+	Start         time.Time
+	// Header are the request headers. They are shared with the original
+	// request, and must not be modified.
+	Header http.Header
+}
+
+// RequestHook is a registered request event consumer. See
+// HTTPStats.OnRequest and HTTPStats.OnRequestAsync.
+type RequestHook struct {
+	stats   *HTTPStats
+	fn      func(RequestEvent)
+	queue   chan RequestEvent
+	done    chan struct{}
+	once    sync.Once
+	dropped int64
+}
+
+// OnRequest registers fn to be invoked after every recorded request. fn is
+// invoked synchronously in the handler chain after the stats have been
+// updated, so it should return quickly. See OnRequestAsync for slower
+// consumers.
+func (s *HTTPStats) OnRequest(fn func(RequestEvent)) *RequestHook {
+	h := &RequestHook{stats: s, fn: fn, done: make(chan struct{})}
+	s.addHook(h)
+	return h
+}
+
+// OnRequestAsync registers fn to be invoked after every recorded request,
+// from a separate goroutine. Events are buffered in a queue of queueSize
+// (defaults to 1024 if less than 1), and when the queue is full, events are
+// dropped rather than blocking the handler. See RequestHook.Dropped.
+func (s *HTTPStats) OnRequestAsync(fn func(RequestEvent), queueSize int) *RequestHook {
+	if queueSize < 1 {
+		queueSize = 1024
+	}
+
+	h := &RequestHook{
+		stats: s,
+		fn:    fn,
+		queue: make(chan RequestEvent, queueSize),
+		done:  make(chan struct{}),
+	}
+
+	go h.consume()
+	s.addHook(h)
+	return h
+}
+
+func (s *HTTPStats) addHook(h *RequestHook) {
+	s.hookMu.Lock()
+	s.hooks = append(s.hooks, h)
+	s.hookMu.Unlock()
+}
+
+// Dropped returns the amount of events which were dropped because the queue
+// was full. Always zero for synchronous hooks.
+func (h *RequestHook) Dropped() int64 {
+	return atomic.LoadInt64(&h.dropped)
+}
+
+// Remove unregisters the hook. Events which are still queued for an
+// asynchronous hook are discarded.
+func (h *RequestHook) Remove() {
+	h.once.Do(func() {
+		h.stats.hookMu.Lock()
+		for i := range h.stats.hooks {
+			if h.stats.hooks[i] == h {
+				h.stats.hooks = append(h.stats.hooks[:i:i], h.stats.hooks[i+1:]...)
+				break
+			}
+		}
+		h.stats.hookMu.Unlock()
+
+		close(h.done)
+	})
+}
+
+func (h *RequestHook) send(event RequestEvent) {
+	if h.queue == nil {
+		h.fn(event)
+		return
+	}
+
+	select {
+	case h.queue <- event:
+	default:
+		atomic.AddInt64(&h.dropped, 1)
+	}
+}
+
+func (h *RequestHook) consume() {
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-h.stats.closer:
+			return
+		case event := <-h.queue:
+			h.fn(event)
+		}
+	}
+}
+
+func (s *HTTPStats) emit(r *http.Request, rr ResponseWriter, start time.Time, dur time.Duration, reqSize int) {
+	s.hookMu.RLock()
+	hooks := s.hooks
+	s.hookMu.RUnlock()
+
+	if len(hooks) == 0 {
+		return
+	}
+
+	event := RequestEvent{
+		Method:        r.Method,
+		Path:          r.URL.Path,
+		RequestURI:    r.RequestURI,
+		Proto:         r.Proto,
+		Host:          r.Host,
+		Status:        rr.Status(),
+		Duration:      dur,
+		BytesIn:       reqSize,
+		ContentLength: r.ContentLength,
+		BytesOut:      rr.BytesWritten(),
+		RemoteAddr:    r.RemoteAddr,
+		Start:         start,
+		Header:        r.Header,
+	}
+
+	if event.RequestURI == "" {
+		event.RequestURI = r.URL.RequestURI()
+	}
+
+	if s.RouteKey != nil {
+		event.Route = s.RouteKey(r)
+	}
+
+	for _, h := range hooks {
+		h.send(event)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package httpstat
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOnRequest(t *testing.T) {
+	stats := New("event_"+strconv.Itoa(time.Now().Nanosecond()), nil)
+	defer stats.Close()
+	stats.RouteKey = func(r *http.Request) string { return "/items/{id}" }
+
+	var got RequestEvent
+	hook := stats.OnRequest(func(event RequestEvent) { got = event })
+
+	async := make(chan RequestEvent, 1)
+	stats.OnRequestAsync(func(event RequestEvent) { async <- event }, 1)
+
+	handler := stats.Record(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		_, _ = w.Write([]byte("short and stout"))
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/items/1?a=b", strings.NewReader("kettle")))
+
+	if got.Method != "POST" || got.Path != "/items/1" || got.RequestURI != "/items/1?a=b" ||
+		got.Route != "/items/{id}" || got.Status != http.StatusTeapot || got.BytesOut != 15 || got.ContentLength != 6 {
+		t.Fatalf("unexpected event: %+v", got)
+	}
+
+	select {
+	case event := <-async:
+		if event.Status != http.StatusTeapot {
+			t.Fatalf("unexpected async event: %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for async event")
+	}
+
+	hook.Remove()
+	got = RequestEvent{}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if got.Method != "" {
+		t.Fatal("removed hook was invoked")
+	}
+}
+
+func TestOnRequestAsyncDrops(t *testing.T) {
+	stats := New("event_"+strconv.Itoa(time.Now().Nanosecond()), nil)
+	defer stats.Close()
+
+	block := make(chan struct{})
+	hook := stats.OnRequestAsync(func(event RequestEvent) { <-block }, 1)
+	defer close(block)
+
+	handler := stats.Record(http.HandlerFunc(dummyHandler))
+	for i := 0; i < 10; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+
+	// One event is being consumed, and one is queued, the remainder are
+	// dropped. The consumer may not have picked up the first yet.
+	if dropped := hook.Dropped(); dropped < 8 || dropped > 9 {
+		t.Fatalf("expected 8-9 dropped events, got %d", dropped)
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+// Package graphite pushes httpstat History snapshots to a Graphite carbon
+// endpoint, using the plaintext protocol.
+package graphite
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lrstanley/httpstat"
+)
+
+// Options are the options used when creating a new Exporter.
+type Options struct {
+	// Addr is the address of the carbon plaintext listener. Defaults to
+	// "127.0.0.1:2003".
+	Addr string
+	// Prefix is prepended to all metric paths. Defaults to "httpstat", and the
+	// namespace of the HTTPStats (e.g. "httpstat.frontend").
+	Prefix string
+	// BufferSize is the maximum amount of lines which are buffered while the
+	// endpoint is unavailable. When full, the oldest lines are dropped.
+	// Defaults to 10000.
+	BufferSize int
+	// MinBackoff and MaxBackoff are the bounds of the exponential backoff
+	// used when reconnecting. Default to 1 second and 1 minute.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Timeout is the timeout used for connecting and writing. Defaults to 5
+	// seconds.
+	Timeout time.Duration
+}
+
+// Exporter writes each History snapshot to a carbon endpoint.
+type Exporter struct {
+	stats  *httpstat.HTTPStats
+	opts   Options
+	remove func()
+
+	mu      sync.Mutex
+	lines   []string
+	head    int64 // Total lines ever removed from the front of lines.
+	dropped int64
+
+	conn    net.Conn
+	backoff time.Duration
+	wake    chan struct{}
+	closer  chan struct{}
+	done    chan struct{}
+}
+
+// New returns a new Exporter, which pushes every new History snapshot of
+// stats. History must be enabled. The connection is established lazily, so
+// New does not fail if the endpoint is unavailable. Make sure Exporter.Close
+// is called when it is no longer needed.
+func New(stats *httpstat.HTTPStats, opts *Options) (*Exporter, error) {
+	if !stats.History.Opts.Enabled {
+		return nil, errors.New("graphite: requested HTTPStats has history disabled")
+	}
+
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	e := &Exporter{
+		stats:  stats,
+		opts:   *opts,
+		wake:   make(chan struct{}, 1),
+		closer: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	if e.opts.Addr == "" {
+		e.opts.Addr = "127.0.0.1:2003"
+	}
+	if e.opts.Prefix == "" {
+		e.opts.Prefix = "httpstat"
+		if ns := stats.Namespace(); ns != "" {
+			e.opts.Prefix += "." + ns
+		}
+	}
+	e.opts.Prefix = strings.TrimSuffix(e.opts.Prefix, ".")
+	if e.opts.BufferSize <= 0 {
+		e.opts.BufferSize = 10000
+	}
+	if e.opts.MinBackoff <= 0 {
+		e.opts.MinBackoff = time.Second
+	}
+	if e.opts.MaxBackoff < e.opts.MinBackoff {
+		e.opts.MaxBackoff = time.Minute
+	}
+	if e.opts.Timeout <= 0 {
+		e.opts.Timeout = 5 * time.Second
+	}
+
+	go e.run()
+	e.remove = stats.History.OnSnapshot(e.snapshot)
+	return e, nil
+}
+
+// Dropped returns the amount of lines which have been dropped, because the
+// buffer was full.
+func (e *Exporter) Dropped() int64 {
+	return atomic.LoadInt64(&e.dropped)
+}
+
+// Close stops the Exporter, and attempts to send any buffered lines.
+func (e *Exporter) Close() error {
+	e.remove()
+	close(e.closer)
+	<-e.done
+
+	err := e.send()
+	if e.conn != nil {
+		if cerr := e.conn.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (e *Exporter) snapshot(elem httpstat.HistoryElem) {
+	ts := " " + strconv.FormatInt(elem.Born.Unix(), 10)
+	fmtFloat := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	fmtInt := func(v int64) string { return strconv.FormatInt(v, 10) }
+
+	lines := []string{
+		e.opts.Prefix + ".requests_total " + fmtInt(elem.RequestsTotal) + ts,
+		e.opts.Prefix + ".requests " + fmtInt(elem.RequestsDiff) + ts,
+		e.opts.Prefix + ".request_errors_total " + fmtInt(elem.RequestErrors) + ts,
+		e.opts.Prefix + ".request_errors " + fmtInt(elem.ErrorsDiff) + ts,
+		e.opts.Prefix + ".rps " + fmtFloat(elem.RPS) + ts,
+		e.opts.Prefix + ".time_total " + fmtFloat(elem.TimeTotal) + ts,
+		e.opts.Prefix + ".time " + fmtFloat(elem.TimeDiff) + ts,
+		e.opts.Prefix + ".bytes_in_total " + fmtInt(elem.BytesInTotal) + ts,
+		e.opts.Prefix + ".bytes_in " + fmtInt(elem.BytesInDiff) + ts,
+		e.opts.Prefix + ".bytes_out_total " + fmtInt(elem.BytesOutTotal) + ts,
+		e.opts.Prefix + ".bytes_out " + fmtInt(elem.BytesOutDiff) + ts,
+		e.opts.Prefix + ".in_flight_peak " + fmtInt(elem.InFlightPeak) + ts,
+	}
+
+	if elem.RequestsDiff > 0 {
+		lines = append(lines,
+			e.opts.Prefix+".latency_mean "+fmtFloat(elem.LatencyMean)+ts,
+			e.opts.Prefix+".latency_p50 "+fmtFloat(elem.LatencyP50)+ts,
+			e.opts.Prefix+".latency_p90 "+fmtFloat(elem.LatencyP90)+ts,
+			e.opts.Prefix+".latency_p99 "+fmtFloat(elem.LatencyP99)+ts,
+		)
+	}
+
+	for code, total := range elem.StatusTotal {
+		lines = append(lines, e.opts.Prefix+".status."+strconv.Itoa(code)+"_total "+fmtInt(total)+ts)
+	}
+
+	e.mu.Lock()
+	e.lines = append(e.lines, lines...)
+	if over := len(e.lines) - e.opts.BufferSize; over > 0 {
+		e.lines = append(e.lines[:0], e.lines[over:]...)
+		e.head += int64(over)
+		atomic.AddInt64(&e.dropped, int64(over))
+	}
+	e.mu.Unlock()
+
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (e *Exporter) run() {
+	defer close(e.done)
+
+	var retry <-chan time.Time
+	for {
+		select {
+		case <-e.closer:
+			return
+		case <-e.wake:
+			if retry != nil {
+				// Still backing off, the buffered lines are sent on retry.
+				continue
+			}
+		case <-retry:
+			retry = nil
+		}
+
+		if err := e.send(); err != nil {
+			if e.backoff == 0 {
+				e.backoff = e.opts.MinBackoff
+			} else if e.backoff *= 2; e.backoff > e.opts.MaxBackoff {
+				e.backoff = e.opts.MaxBackoff
+			}
+			retry = time.After(e.backoff)
+			continue
+		}
+		e.backoff = 0
+	}
+}
+
+// send writes all buffered lines, connecting if necessary. Lines are only
+// removed from the buffer once written. Only called from run, or after run
+// has returned.
+func (e *Exporter) send() error {
+	e.mu.Lock()
+	lines := append([]string(nil), e.lines...)
+	head := e.head
+	e.mu.Unlock()
+
+	if len(lines) == 0 {
+		return nil
+	}
+
+	var err error
+	if e.conn == nil {
+		e.conn, err = net.DialTimeout("tcp", e.opts.Addr, e.opts.Timeout)
+		if err != nil {
+			e.conn = nil
+			return err
+		}
+	}
+
+	_ = e.conn.SetWriteDeadline(time.Now().Add(e.opts.Timeout))
+	w := bufio.NewWriter(e.conn)
+	for _, line := range lines {
+		_, _ = w.WriteString(line)
+		_ = w.WriteByte('\n')
+	}
+
+	if err = w.Flush(); err != nil {
+		e.conn.Close()
+		e.conn = nil
+		return err
+	}
+
+	e.mu.Lock()
+	// Lines may have been dropped from the front while writing, in which case
+	// they no longer need to be removed.
+	if sent := int64(len(lines)) - (e.head - head); sent > 0 {
+		e.lines = append(e.lines[:0], e.lines[sent:]...)
+		e.head += sent
+	}
+	e.mu.Unlock()
+
+	return nil
+}
@@ -0,0 +1,87 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package graphite
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lrstanley/httpstat"
+)
+
+func TestExporterReconnect(t *testing.T) {
+	// Reserve an address, and close it so the first attempts fail.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	stats := httpstat.New("graphite_"+strconv.Itoa(time.Now().Nanosecond()), &httpstat.HistoryOptions{
+		Enabled:    true,
+		Resolution: time.Hour,
+	})
+	defer stats.Close()
+
+	e, err := New(stats, &Options{Addr: addr, Prefix: "app", BufferSize: 20, MinBackoff: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	born := time.Unix(1500000000, 0)
+	e.snapshot(httpstat.HistoryElem{Born: born, RequestsTotal: 1})
+	e.snapshot(httpstat.HistoryElem{Born: born.Add(time.Second), RequestsTotal: 5, RequestsDiff: 4})
+
+	if dropped := e.Dropped(); dropped != 8 {
+		t.Fatalf("expected 8 dropped lines, got %d", dropped)
+	}
+
+	// Give the exporter a chance to fail to connect at least once.
+	time.Sleep(50 * time.Millisecond)
+
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("unable to re-listen on %s: %v", addr, err)
+	}
+	defer ln.Close()
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var lines []string
+	scanner := bufio.NewScanner(conn)
+	for len(lines) < 20 && scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	if !contains(lines, "app.requests_total 5 1500000001") || !contains(lines, "app.requests 4 1500000001") {
+		t.Fatalf("missing expected lines, got %q", lines)
+	}
+
+	for _, line := range lines {
+		if strings.HasSuffix(line, " 1500000000") && strings.HasPrefix(line, "app.requests_total") {
+			t.Fatalf("expected oldest lines to be dropped, got %q", line)
+		}
+	}
+}
+
+func contains(lines []string, s string) bool {
+	for _, line := range lines {
+		if line == s {
+			return true
+		}
+	}
+	return false
+}
@@ -5,6 +5,9 @@
 package httpstat
 
 import (
+	"expvar"
+	"log"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -24,92 +27,397 @@ type HistoryOptions struct {
 	// 10 seconds and MaxResolution is 5 minutes, that would be (5*60)/10 (or
 	// 30) datapoints. Defaults to 5 seconds.
 	Resolution time.Duration
+	// Store, if set, is used to persist history across restarts. Snapshots
+	// are loaded when calling New (skipping any older than MaxResolution),
+	// and saved every SaveInterval, and when calling HTTPStats.Close.
+	Store HistoryStore
+	// SaveInterval is how often history is saved to Store. Defaults to 1
+	// minute.
+	SaveInterval time.Duration
+	// Tiers are additional, coarser, tiers of history (e.g. 1 minute
+	// snapshots for 24 hours, and 1 hour snapshots for 30 days), which are
+	// consolidated from the base snapshots. See History.ElemsFor.
+	Tiers []HistoryTier
+	// AlignSnapshots aligns snapshots to multiples of Resolution, rather than
+	// to when New was called (e.g. at :00, :05, :10, etc, with a 5 second
+	// resolution), so snapshots from multiple processes line up.
+	AlignSnapshots bool
+	// Clock, if set, replaces the system clock for HTTPStats, History and
+	// UptimeVar. Mostly useful in tests, to advance time deterministically.
+	// Note that it is used even if Enabled is false.
+	Clock Clock
 }
 
 // HistoryElem is a snapshot of the http stats from a previous point of time.
+// Fields suffixed with "Diff" are the change since the previous snapshot.
 type HistoryElem struct {
 	Born          time.Time
 	TimeTotal     float64
 	TimeDiff      float64
 	RequestErrors int64
+	ErrorsDiff    int64
 	RequestsTotal int64
 	RequestsDiff  int64
-	RPS           int64
+	// RPS is the average requests per second since the previous snapshot.
+	RPS float64
+
+	BytesInTotal  int64
+	BytesInDiff   int64
+	BytesOutTotal int64
+	BytesOutDiff  int64
+
+	// StatusTotal and StatusDiff are keyed by status code. See
+	// HistoryElem.StatusClassDiff for status classes.
+	StatusTotal map[int]int64
+	StatusDiff  map[int]int64
+
+	// LatencyMean, and the Latency percentiles, are in seconds, for requests
+	// since the previous snapshot. Percentiles are estimates, see
+	// LatencyBuckets.
+	LatencyMean float64
+	LatencyP50  float64
+	LatencyP90  float64
+	LatencyP99  float64
+	// LatencyCounts are the amount of requests since the previous snapshot
+	// within each of LatencyBuckets, with an extra trailing bucket for
+	// requests slower than the largest bucket. It is nil if there were no
+	// requests.
+	LatencyCounts []int64 `json:",omitempty"`
+
+	// InFlightPeak is the highest amount of concurrent requests since the
+	// previous snapshot.
+	InFlightPeak int64
+}
+
+// StatusClassDiff returns the amount of requests since the previous snapshot
+// within the given status class (e.g. 5 for 5xx).
+func (e *HistoryElem) StatusClassDiff(class int) (count int64) {
+	for code, diff := range e.StatusDiff {
+		if code/100 == class {
+			count += diff
+		}
+	}
+	return count
+}
+
+// clone returns a copy of e, which doesn't share its maps and slices.
+func (e HistoryElem) clone() HistoryElem {
+	if e.StatusTotal != nil {
+		e.StatusTotal = copyStatus(e.StatusTotal)
+	}
+	if e.StatusDiff != nil {
+		e.StatusDiff = copyStatus(e.StatusDiff)
+	}
+	if e.LatencyCounts != nil {
+		e.LatencyCounts = append([]int64(nil), e.LatencyCounts...)
+	}
+	return e
+}
+
+func copyStatus(m map[int]int64) map[int]int64 {
+	out := make(map[int]int64, len(m))
+	for code, n := range m {
+		out[code] = n
+	}
+	return out
 }
 
 // History holds the previous historical elements, and options for how long
-// and how much to store.
+// and how much to store. Elements are stored in fixed-capacity ring buffers,
+// sized from MaxResolution/Resolution, so memory usage doesn't grow over
+// time.
 type History struct {
 	Opts  HistoryOptions
 	mu    sync.RWMutex
-	elems []HistoryElem
+	elems *elemRing
+
+	tiers       []*historyTier
+	lastBuckets []int64
+	// started is when the History was set up. Elements born before it were
+	// loaded from a HistoryStore, and taken by a previous process.
+	started time.Time
+	// done is closed once the watcher has returned.
+	done chan struct{}
+
+	hookMu sync.RWMutex
+	hookID int
+	hooks  map[int]func(HistoryElem)
 }
 
-// Elems returns the list previous history iterations.
+// Elems returns a copy of the previous history iterations, of the base tier.
+// Like all snapshots returned by History, they don't share any maps or slices
+// with those stored, so they can be modified.
 func (h *History) Elems() []HistoryElem {
 	h.mu.RLock()
-	elems := h.elems
-	h.mu.RUnlock()
+	defer h.mu.RUnlock()
 
-	return elems
+	if h.elems == nil {
+		return nil
+	}
+	return h.elems.appendTo(make([]HistoryElem, 0, h.elems.len()), time.Time{}, time.Time{})
+}
+
+// Last returns the most recent snapshot of the base tier, if any.
+func (h *History) Last() (elem HistoryElem, ok bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.elems == nil {
+		return elem, false
+	}
+	elem, ok = h.elems.last()
+	return elem.clone(), ok
+}
+
+// Range calls fn for each element of the base tier born within [from, to],
+// oldest first, until fn returns false. A zero from or to is unbounded. fn is
+// called with a read lock held, so it must not call other History methods.
+func (h *History) Range(from, to time.Time, fn func(elem HistoryElem) bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.elems == nil {
+		return
+	}
+
+	i := 0
+	if !from.IsZero() {
+		i = h.elems.search(from)
+	}
+
+	for ; i < h.elems.len(); i++ {
+		elem := h.elems.at(i)
+		if !to.IsZero() && elem.Born.After(to) {
+			return
+		}
+		if !fn(elem.clone()) {
+			return
+		}
+	}
+}
+
+// OnSnapshot registers fn to be invoked with every new HistoryElem, right
+// after it has been stored. fn is invoked from the goroutine taking the
+// snapshots, so it should return quickly. The returned function removes the
+// registration.
+func (h *History) OnSnapshot(fn func(HistoryElem)) (remove func()) {
+	h.hookMu.Lock()
+	if h.hooks == nil {
+		h.hooks = make(map[int]func(HistoryElem))
+	}
+	h.hookID++
+	id := h.hookID
+	h.hooks[id] = fn
+	h.hookMu.Unlock()
+
+	return func() {
+		h.hookMu.Lock()
+		delete(h.hooks, id)
+		h.hookMu.Unlock()
+	}
 }
 
 func (h *History) add(stats *HTTPStats) {
 	h.truncate()
 
+	born := h.Opts.Clock.Now()
+	if h.Opts.AlignSnapshots {
+		// Timers fire slightly late, so round to the nearest boundary.
+		born = born.Round(h.Opts.Resolution)
+	}
+
 	elem := HistoryElem{
-		Born:          time.Now(),
+		Born:          born,
 		TimeTotal:     stats.TimeTotal.Value(),
 		RequestErrors: stats.RequestErrorsTotal.Value(),
 		RequestsTotal: stats.RequestsTotal.Value(),
+		BytesInTotal:  stats.BytesInTotal.Value(),
+		BytesOutTotal: stats.BytesOutTotal.Value(),
+		StatusTotal:   make(map[int]int64),
+		StatusDiff:    make(map[int]int64),
+		InFlightPeak:  stats.resetInFlightPeak(),
 	}
 
-	h.mu.RLock()
-	if len(h.elems) > 0 {
-		elem.RequestsDiff = elem.RequestsTotal - h.elems[len(h.elems)-1].RequestsTotal
-		elem.TimeDiff = elem.TimeTotal - h.elems[len(h.elems)-1].TimeTotal
+	stats.StatusTotal.Do(func(kv expvar.KeyValue) {
+		code, _ := strconv.Atoi(kv.Key)
+		elem.StatusTotal[code], _ = strconv.ParseInt(kv.Value.String(), 10, 64)
+	})
+
+	buckets := stats.latency.snapshot()
 
-		if elem.RequestsDiff > 0 {
-			elem.RPS = elem.RequestsDiff / int64(h.Opts.Resolution.Seconds())
+	h.mu.Lock()
+	prev, ok := h.elems.last()
+	if !ok {
+		prev = HistoryElem{Born: elem.Born.Add(-h.Opts.Resolution)}
+	}
+
+	// The counters (and latency buckets) reset when restarting, so the first
+	// snapshot after loading elements from a HistoryStore is relative to when
+	// this process started, rather than to the last loaded element.
+	if prev.Born.Before(h.started) {
+		prev = HistoryElem{Born: h.started}
+	} else if elem.RequestsTotal < prev.RequestsTotal {
+		prev = HistoryElem{Born: prev.Born}
+	}
+
+	elem.RequestsDiff = elem.RequestsTotal - prev.RequestsTotal
+	elem.TimeDiff = elem.TimeTotal - prev.TimeTotal
+	elem.ErrorsDiff = elem.RequestErrors - prev.RequestErrors
+	elem.BytesInDiff = elem.BytesInTotal - prev.BytesInTotal
+	elem.BytesOutDiff = elem.BytesOutTotal - prev.BytesOutTotal
+
+	for code, total := range elem.StatusTotal {
+		if diff := total - prev.StatusTotal[code]; diff > 0 {
+			elem.StatusDiff[code] = diff
 		}
-	} else if elem.RequestsTotal > 0 {
-		elem.RPS = elem.RequestsTotal / int64(h.Opts.Resolution.Seconds())
 	}
-	h.mu.RUnlock()
 
-	h.mu.Lock()
-	h.elems = append(h.elems, elem)
+	if elapsed := elem.Born.Sub(prev.Born).Seconds(); elapsed > 0 {
+		elem.RPS = float64(elem.RequestsDiff) / elapsed
+	}
+
+	if elem.RequestsDiff > 0 {
+		elem.LatencyMean = elem.TimeDiff / float64(elem.RequestsDiff)
+
+		counts := make([]int64, len(buckets))
+		for i := range buckets {
+			counts[i] = buckets[i]
+			if i < len(h.lastBuckets) {
+				counts[i] -= h.lastBuckets[i]
+			}
+		}
+
+		elem.LatencyCounts = counts
+		elem.LatencyP50 = percentile(counts, 0.5)
+		elem.LatencyP90 = percentile(counts, 0.9)
+		elem.LatencyP99 = percentile(counts, 0.99)
+	}
+	h.lastBuckets = buckets
+
+	h.elems.push(elem)
+	h.rollup(elem)
 	h.mu.Unlock()
+
+	// Copy the hooks, so they can be invoked without holding the lock, and
+	// are free to remove (or register) hooks themselves.
+	h.hookMu.RLock()
+	hooks := make([]func(HistoryElem), 0, len(h.hooks))
+	for _, fn := range h.hooks {
+		hooks = append(hooks, fn)
+	}
+	h.hookMu.RUnlock()
+
+	for _, fn := range hooks {
+		fn(elem.clone())
+	}
 }
 
 func (h *History) watcher(stat *HTTPStats) {
-	ticker := time.NewTicker(stat.History.Opts.Resolution)
+	defer close(h.done)
+
+	clock := h.Opts.Clock
+	next := h.nextSnapshot(clock.Now())
+	tick := clock.After(next.Sub(clock.Now()))
+
+	var save <-chan time.Time
+	if h.Opts.Store != nil {
+		save = clock.After(h.Opts.SaveInterval)
+	}
 
 	for {
 		select {
 		case <-stat.closer:
 			return
-		case <-ticker.C:
+		case <-tick:
 			h.add(stat)
+
+			// If we've fallen behind (e.g. the process was paused), skip the
+			// missed snapshots rather than taking them all at once.
+			now := clock.Now()
+			if next = h.nextSnapshot(next); next.Before(now) {
+				next = h.nextSnapshot(now)
+			}
+			tick = clock.After(next.Sub(now))
+		case <-save:
+			h.save()
+			save = clock.After(h.Opts.SaveInterval)
 		}
 	}
 }
 
-func (h *History) truncate() {
+// nextSnapshot returns when the snapshot after the one at t should be taken.
+func (h *History) nextSnapshot(t time.Time) time.Time {
+	if h.Opts.AlignSnapshots {
+		return t.Truncate(h.Opts.Resolution).Add(h.Opts.Resolution)
+	}
+	return t.Add(h.Opts.Resolution)
+}
+
+// load loads any previously saved history from the store, which is still
+// within MaxResolution (or the MaxResolution of each tier). Saved tiers which
+// no longer match Opts.Tiers are ignored, and rebuilt from the base snapshots
+// instead.
+func (h *History) load() {
+	elems, tiers, err := h.Opts.Store.Load()
+	if err != nil {
+		log.Printf("httpstat: error loading history: %s", err)
+		return
+	}
+
 	h.mu.Lock()
+	for _, tier := range h.tiers {
+		for _, saved := range tiers {
+			if saved.Resolution != tier.opts.Resolution {
+				continue
+			}
 
-	truncateTo := -1
-	for i := 0; i < len(h.elems); i++ {
-		if time.Since(h.elems[i].Born) > h.Opts.MaxResolution {
-			truncateTo = i
-			continue
+			for _, elem := range saved.Elems {
+				tier.elems.push(elem)
+			}
+			break
 		}
+	}
 
-		break
+	// Base snapshots which were already consolidated into a saved tier are
+	// skipped, the rest are left pending for the next consolidated snapshot.
+	for _, elem := range elems {
+		h.elems.push(elem)
+		for _, tier := range h.tiers {
+			if last, ok := tier.elems.last(); !ok || elem.Born.After(last.Born) {
+				tier.add(elem)
+			}
+		}
 	}
+	h.mu.Unlock()
+
+	h.truncate()
+}
+
+func (h *History) save() {
+	elems := h.Elems()
 
-	if truncateTo > -1 {
-		h.elems = append(h.elems[:0], h.elems[truncateTo+1:]...)
+	h.mu.RLock()
+	tiers := make([]HistoryTierElems, len(h.tiers))
+	for i, tier := range h.tiers {
+		tiers[i] = HistoryTierElems{
+			Resolution: tier.opts.Resolution,
+			Elems:      tier.elems.appendTo(make([]HistoryElem, 0, tier.elems.len()), time.Time{}, time.Time{}),
+		}
+	}
+	h.mu.RUnlock()
+
+	if err := h.Opts.Store.Save(elems, tiers); err != nil {
+		log.Printf("httpstat: error saving history: %s", err)
+	}
+}
+
+func (h *History) truncate() {
+	now := h.Opts.Clock.Now()
+
+	h.mu.Lock()
+	h.elems.dropBefore(now.Add(-h.Opts.MaxResolution))
+	for _, tier := range h.tiers {
+		tier.elems.dropBefore(now.Add(-tier.opts.MaxResolution))
 	}
 	h.mu.Unlock()
 }
@@ -0,0 +1,269 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package httpstat
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HistoryQuery selects a range of snapshots from History. See History.Query.
+type HistoryQuery struct {
+	// From and To are the (inclusive) bounds of the query. A zero From or To
+	// is unbounded.
+	From time.Time
+	To   time.Time
+	// Step, if larger than the resolution of the selected tier, consolidates
+	// snapshots into one per Step.
+	Step time.Duration
+}
+
+// Query returns the snapshots matching q, from the finest tier which still
+// retains From (see HistoryOptions.Tiers).
+func (h *History) Query(q HistoryQuery) []HistoryElem {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	elems := h.elems
+	if elems == nil {
+		return nil
+	}
+	resolution := h.Opts.Resolution
+
+	if !q.From.IsZero() {
+		window := h.Opts.Clock.Now().Sub(q.From)
+		if window > h.Opts.MaxResolution {
+			for _, tier := range h.tiers {
+				elems, resolution = tier.elems, tier.opts.Resolution
+				if tier.opts.MaxResolution >= window {
+					break
+				}
+			}
+		}
+	}
+
+	out := elems.appendTo(nil, q.From, q.To)
+	if q.Step > resolution {
+		out = downsample(out, q.Step, resolution)
+	}
+	return out
+}
+
+// downsample consolidates elems, each covering resolution, into one snapshot
+// per step, aligned to multiples of step.
+func downsample(elems []HistoryElem, step, resolution time.Duration) []HistoryElem {
+	var out []HistoryElem
+
+	for start := 0; start < len(elems); {
+		bucket := elems[start].Born.Truncate(step)
+
+		end := start + 1
+		for end < len(elems) && elems[end].Born.Truncate(step).Equal(bucket) {
+			end++
+		}
+
+		out = append(out, consolidate(elems[start:end], resolution))
+		start = end
+	}
+
+	return out
+}
+
+// ParseHistoryQuery parses a HistoryQuery from the "from", "to", "window" and
+// "step" query parameters of r. from and to can be RFC3339 timestamps, unix
+// timestamps, or durations relative to now (e.g. from=15m). window (e.g.
+// window=1h) is shorthand for a from relative to now.
+func ParseHistoryQuery(r *http.Request, now time.Time) (q HistoryQuery, err error) {
+	if q.From, err = parseQueryTime(r.FormValue("from"), now); err != nil {
+		return q, fmt.Errorf("invalid from: %w", err)
+	}
+	if q.To, err = parseQueryTime(r.FormValue("to"), now); err != nil {
+		return q, fmt.Errorf("invalid to: %w", err)
+	}
+
+	if v := r.FormValue("window"); v != "" {
+		window, err := time.ParseDuration(v)
+		if err != nil || window <= 0 {
+			return q, fmt.Errorf("invalid window: %q", v)
+		}
+
+		end := q.To
+		if end.IsZero() {
+			end = now
+		}
+		q.From = end.Add(-window)
+	}
+
+	if v := r.FormValue("step"); v != "" {
+		if q.Step, err = time.ParseDuration(v); err != nil || q.Step < 0 {
+			return q, fmt.Errorf("invalid step: %q", v)
+		}
+	}
+
+	return q, nil
+}
+
+func parseQueryTime(v string, now time.Time) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	if unix, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+
+	if ago, err := time.ParseDuration(strings.TrimPrefix(v, "-")); err == nil {
+		return now.Add(-ago), nil
+	}
+
+	return time.Parse(time.RFC3339, v)
+}
+
+type historyField struct {
+	name  string
+	value func(e *HistoryElem) interface{}
+}
+
+// historyFields are the fields available from History.ServeHTTP, in their
+// default order.
+var historyFields = []historyField{
+	{"born", func(e *HistoryElem) interface{} { return e.Born.UTC().Format(time.RFC3339Nano) }},
+	{"requests_total", func(e *HistoryElem) interface{} { return e.RequestsTotal }},
+	{"requests", func(e *HistoryElem) interface{} { return e.RequestsDiff }},
+	{"rps", func(e *HistoryElem) interface{} { return e.RPS }},
+	{"errors_total", func(e *HistoryElem) interface{} { return e.RequestErrors }},
+	{"errors", func(e *HistoryElem) interface{} { return e.ErrorsDiff }},
+	{"time_total", func(e *HistoryElem) interface{} { return e.TimeTotal }},
+	{"time", func(e *HistoryElem) interface{} { return e.TimeDiff }},
+	{"bytes_in_total", func(e *HistoryElem) interface{} { return e.BytesInTotal }},
+	{"bytes_in", func(e *HistoryElem) interface{} { return e.BytesInDiff }},
+	{"bytes_out_total", func(e *HistoryElem) interface{} { return e.BytesOutTotal }},
+	{"bytes_out", func(e *HistoryElem) interface{} { return e.BytesOutDiff }},
+	{"status_1xx", func(e *HistoryElem) interface{} { return e.StatusClassDiff(1) }},
+	{"status_2xx", func(e *HistoryElem) interface{} { return e.StatusClassDiff(2) }},
+	{"status_3xx", func(e *HistoryElem) interface{} { return e.StatusClassDiff(3) }},
+	{"status_4xx", func(e *HistoryElem) interface{} { return e.StatusClassDiff(4) }},
+	{"status_5xx", func(e *HistoryElem) interface{} { return e.StatusClassDiff(5) }},
+	{"latency_mean", func(e *HistoryElem) interface{} { return e.LatencyMean }},
+	{"latency_p50", func(e *HistoryElem) interface{} { return e.LatencyP50 }},
+	{"latency_p90", func(e *HistoryElem) interface{} { return e.LatencyP90 }},
+	{"latency_p99", func(e *HistoryElem) interface{} { return e.LatencyP99 }},
+	{"in_flight_peak", func(e *HistoryElem) interface{} { return e.InFlightPeak }},
+	{"latency_counts", func(e *HistoryElem) interface{} { return e.LatencyCounts }},
+}
+
+// explicitHistoryFields are only included when requested by name.
+var explicitHistoryFields = map[string]bool{"latency_counts": true}
+
+// ServeHTTP returns the history as JSON (the default), or CSV (with
+// ?format=csv). Mount it using a pointer, e.g. &stats.History. The following
+// query parameters are supported:
+//
+//	from, to, window, step  see ParseHistoryQuery
+//	fields                  comma separated list of fields to include (e.g.
+//	                        fields=born,rps,latency_p99), defaults to all
+//	                        but latency_counts
+//	format                  either json or csv
+func (h *History) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.Opts.Enabled {
+		http.Error(w, "history is disabled", http.StatusNotFound)
+		return
+	}
+
+	q, err := ParseHistoryQuery(r, h.Opts.Clock.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var fields []historyField
+	for _, field := range historyFields {
+		if !explicitHistoryFields[field.name] {
+			fields = append(fields, field)
+		}
+	}
+
+	if v := r.FormValue("fields"); v != "" {
+		fields = nil
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+
+			found := false
+			for _, field := range historyFields {
+				if field.name == name {
+					fields = append(fields, field)
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				http.Error(w, fmt.Sprintf("unknown field: %q", name), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	elems := h.Query(q)
+
+	switch format := r.FormValue("format"); format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		cw := csv.NewWriter(w)
+
+		row := make([]string, len(fields))
+		for i := range fields {
+			row[i] = fields[i].name
+		}
+		_ = cw.Write(row)
+
+		for i := range elems {
+			for j := range fields {
+				switch value := fields[j].value(&elems[i]).(type) {
+				case []int64:
+					row[j] = strings.Trim(fmt.Sprint(value), "[]")
+				default:
+					row[j] = fmt.Sprint(value)
+				}
+			}
+			_ = cw.Write(row)
+		}
+		cw.Flush()
+	case "", "json":
+		buf := &bytes.Buffer{}
+		buf.WriteByte('[')
+		for i := range elems {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+
+			buf.WriteByte('{')
+			for j := range fields {
+				if j > 0 {
+					buf.WriteByte(',')
+				}
+
+				value, err := json.Marshal(fields[j].value(&elems[i]))
+				if err != nil {
+					panic(err)
+				}
+				fmt.Fprintf(buf, "%q:%s", fields[j].name, value)
+			}
+			buf.WriteByte('}')
+		}
+		buf.WriteString("]\n")
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(buf.Bytes())
+	default:
+		http.Error(w, fmt.Sprintf("unknown format: %q", format), http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package httpstat
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newQueryHistory(t *testing.T) (*History, time.Time) {
+	t.Helper()
+
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	h := &History{Opts: HistoryOptions{
+		Enabled:       true,
+		Resolution:    time.Second,
+		MaxResolution: time.Minute,
+		Clock:         newFakeClock(now),
+	}}
+	h.setup()
+
+	for i := 0; i < 60; i++ {
+		h.elems.push(HistoryElem{
+			Born:          now.Add(time.Duration(i-59) * time.Second),
+			RequestsTotal: int64(i+1) * 2,
+			RequestsDiff:  2,
+			RPS:           2,
+		})
+	}
+	return h, now
+}
+
+func TestHistoryQuery(t *testing.T) {
+	h, now := newQueryHistory(t)
+
+	elems := h.Query(HistoryQuery{From: now.Add(-9 * time.Second)})
+	if len(elems) != 10 {
+		t.Fatalf("expected 10 elems, got %d", len(elems))
+	}
+
+	elems = h.Query(HistoryQuery{From: now.Add(-20 * time.Second), To: now.Add(-11 * time.Second), Step: 5 * time.Second})
+	if len(elems) != 2 {
+		t.Fatalf("expected 2 downsampled elems, got %d: %+v", len(elems), elems)
+	}
+	if elems[0].RequestsDiff != 10 || elems[0].RPS != 2 || elems[1].RequestsTotal != 98 {
+		t.Fatalf("unexpected downsampled elems: %+v", elems)
+	}
+
+	// Both buckets only cover part of the minute, but have the same rate,
+	// rather than a fraction of it.
+	elems = h.Query(HistoryQuery{From: now.Add(-9 * time.Second), Step: time.Minute})
+	if len(elems) != 2 || elems[0].RequestsDiff != 18 || elems[0].RPS != 2 || elems[1].RPS != 2 {
+		t.Fatalf("unexpected partial bucket: %+v", elems)
+	}
+}
+
+func TestParseHistoryQuery(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		query   string
+		want    HistoryQuery
+		wantErr bool
+	}{
+		{query: "", want: HistoryQuery{}},
+		{query: "window=1h&step=1m", want: HistoryQuery{From: now.Add(-time.Hour), Step: time.Minute}},
+		{query: "from=15m&to=-5m", want: HistoryQuery{From: now.Add(-15 * time.Minute), To: now.Add(-5 * time.Minute)}},
+		{query: "from=1577880000", want: HistoryQuery{From: time.Unix(1577880000, 0)}},
+		{query: "from=2020-01-01T11:00:00Z", want: HistoryQuery{From: now.Add(-time.Hour)}},
+		{query: "from=yesterday", wantErr: true},
+		{query: "window=-1h", wantErr: true},
+		{query: "step=fast", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		q, err := ParseHistoryQuery(httptest.NewRequest("GET", "/?"+tt.query, nil), now)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%q: unexpected error: %v", tt.query, err)
+		}
+		if tt.wantErr {
+			continue
+		}
+
+		if !q.From.Equal(tt.want.From) || !q.To.Equal(tt.want.To) || q.Step != tt.want.Step {
+			t.Fatalf("%q: expected %+v, got %+v", tt.query, tt.want, q)
+		}
+	}
+}
+
+func TestHistoryServeHTTP(t *testing.T) {
+	h, _ := newQueryHistory(t)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/?window=2s&fields=born,requests,rps", nil))
+
+	var out []map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatalf("invalid json %q: %v", rec.Body.String(), err)
+	}
+	if len(out) != 3 || len(out[0]) != 3 || out[2]["born"] != "2020-01-01T12:00:00Z" || out[2]["requests"] != float64(2) {
+		t.Fatalf("unexpected json: %v", out)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/?format=csv&window=1s&fields=born,requests_total", nil))
+
+	want := "born,requests_total\n2020-01-01T11:59:59Z,118\n2020-01-01T12:00:00Z,120\n"
+	if got := rec.Body.String(); got != want {
+		t.Fatalf("expected csv %q, got %q", want, got)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+
+	for _, query := range []string{"fields=nope", "format=xml", "from=never"} {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/?"+query, nil))
+		if rec.Code != 400 {
+			t.Fatalf("%q: expected 400, got %d", query, rec.Code)
+		}
+	}
+}
+
+func TestHistoryServeHTTPLatencyCounts(t *testing.T) {
+	h, now := newQueryHistory(t)
+	h.elems.push(HistoryElem{Born: now.Add(time.Second), LatencyCounts: []int64{1, 0, 2}})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/?format=csv&from="+now.Add(time.Second).Format(time.RFC3339), nil))
+	if strings.Contains(rec.Body.String(), "latency_counts") {
+		t.Fatalf("expected latency_counts to be excluded by default, got %q", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/?format=csv&fields=latency_counts&from="+now.Add(time.Second).Format(time.RFC3339), nil))
+	if want := "latency_counts\n1 0 2\n"; rec.Body.String() != want {
+		t.Fatalf("expected csv %q, got %q", want, rec.Body.String())
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package httpstat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// HistoryStore persists History snapshots, so they can survive restarts. See
+// HistoryOptions.Store.
+type HistoryStore interface {
+	// Load returns the previously saved snapshots of the base tier, and of
+	// each of HistoryOptions.Tiers, oldest first. If nothing has been saved
+	// yet, Load should return no snapshots, and no error.
+	Load() (elems []HistoryElem, tiers []HistoryTierElems, err error)
+	// Save replaces the saved snapshots with elems, and those of tiers.
+	Save(elems []HistoryElem, tiers []HistoryTierElems) error
+}
+
+// HistoryTierElems are the snapshots of a single tier of history, see
+// HistoryOptions.Tiers.
+type HistoryTierElems struct {
+	Resolution time.Duration `json:"resolution"`
+	Elems      []HistoryElem `json:"elems"`
+}
+
+// historyFileVersion is the current version of the FileStore format. Bump it
+// when HistoryElem changes in an incompatible way. Version 1 only held the
+// snapshots of the base tier, and can still be loaded.
+const historyFileVersion = 2
+
+type historyFile struct {
+	Version int                `json:"version"`
+	Elems   []HistoryElem      `json:"elems"`
+	Tiers   []HistoryTierElems `json:"tiers,omitempty"`
+}
+
+// FileStore is a HistoryStore which saves snapshots to a single JSON file.
+// Writes are atomic, by writing to a temporary file first, and renaming it.
+type FileStore struct {
+	Path string
+}
+
+// Load implements the HistoryStore interface.
+func (s *FileStore) Load() ([]HistoryElem, []HistoryTierElems, error) {
+	b, err := os.ReadFile(s.Path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	var file historyFile
+	if err = json.Unmarshal(b, &file); err != nil {
+		return nil, nil, fmt.Errorf("httpstat: invalid history file %q: %w", s.Path, err)
+	}
+
+	if file.Version < 1 || file.Version > historyFileVersion {
+		return nil, nil, fmt.Errorf("httpstat: unsupported history file version %d in %q", file.Version, s.Path)
+	}
+
+	return file.Elems, file.Tiers, nil
+}
+
+// Save implements the HistoryStore interface.
+func (s *FileStore) Save(elems []HistoryElem, tiers []HistoryTierElems) error {
+	b, err := json.Marshal(historyFile{Version: historyFileVersion, Elems: elems, Tiers: tiers})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.Path), "."+filepath.Base(s.Path)+".tmp*")
+	if err != nil {
+		return err
+	}
+
+	if _, err = tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	// Flush the data to disk before the rename, so a crash can't leave an
+	// empty or partial file in place of the previous one.
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err = os.Rename(tmp.Name(), s.Path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return nil
+}
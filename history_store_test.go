@@ -0,0 +1,124 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package httpstat
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestFileStore(t *testing.T) {
+	store := &FileStore{Path: filepath.Join(t.TempDir(), "history.json")}
+
+	elems, tiers, err := store.Load()
+	if err != nil || len(elems) != 0 || len(tiers) != 0 {
+		t.Fatalf("expected no elems and no error for missing file, got %v, %v", elems, err)
+	}
+
+	now := time.Now().Truncate(time.Second)
+	stale := HistoryElem{Born: now.Add(-time.Hour), RequestsTotal: 1}
+	fresh := HistoryElem{Born: now.Add(-time.Minute), RequestsTotal: 10, RequestsDiff: 9, TimeTotal: 1.5}
+	if err = store.Save([]HistoryElem{stale, fresh}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := New("store_"+strconv.Itoa(time.Now().Nanosecond()), &HistoryOptions{
+		Enabled:       true,
+		Resolution:    time.Minute,
+		MaxResolution: 30 * time.Minute,
+		Store:         store,
+	})
+
+	elems = stats.History.Elems()
+	if len(elems) != 1 || !elems[0].Born.Equal(fresh.Born) || elems[0].RequestsTotal != 10 {
+		t.Fatalf("expected only the fresh elem to be loaded, got %+v", elems)
+	}
+
+	// Counters restart from zero, so the first snapshot is relative to zero,
+	// even when the totals are already past those of the loaded elements.
+	stats.RequestsTotal.Add(30)
+	stats.StatusTotal.Add("200", 30)
+	stats.History.add(stats)
+	if elems = stats.History.Elems(); elems[1].RequestsDiff != 30 || elems[1].StatusDiff[200] != 30 {
+		t.Fatalf("expected diff of 30 after restart, got %+v", elems[1])
+	}
+
+	stats.RequestsTotal.Add(5)
+	stats.History.add(stats)
+	if elems = stats.History.Elems(); elems[2].RequestsDiff != 5 {
+		t.Fatalf("expected diff of 5 after the first snapshot, got %+v", elems[2])
+	}
+
+	stats.Close()
+	if elems, _, err = store.Load(); err != nil || len(elems) != 3 {
+		t.Fatalf("expected 3 saved elems, got %+v, %v", elems, err)
+	}
+
+	if err = os.WriteFile(store.Path, []byte(`{"version":999,"elems":[]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = store.Load(); err == nil {
+		t.Fatal("expected error for unsupported version")
+	}
+}
+
+func TestFileStoreTiers(t *testing.T) {
+	store := &FileStore{Path: filepath.Join(t.TempDir(), "history.json")}
+
+	now := time.Now().Truncate(time.Second)
+	consolidated := HistoryElem{Born: now.Add(-40 * time.Minute), RequestsTotal: 5, RequestsDiff: 5}
+	covered := HistoryElem{Born: now.Add(-3 * time.Minute), RequestsTotal: 5}
+	fresh := HistoryElem{Born: now.Add(-time.Minute), RequestsTotal: 10, RequestsDiff: 5}
+	err := store.Save([]HistoryElem{covered, fresh}, []HistoryTierElems{
+		{Resolution: 2 * time.Minute, Elems: []HistoryElem{consolidated, {Born: covered.Born, RequestsTotal: 5}}},
+		{Resolution: time.Hour, Elems: []HistoryElem{{Born: now.Add(-time.Hour)}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := New("store_"+strconv.Itoa(time.Now().Nanosecond()), &HistoryOptions{
+		Enabled:       true,
+		Resolution:    time.Minute,
+		MaxResolution: 30 * time.Minute,
+		Store:         store,
+		Tiers:         []HistoryTier{{Resolution: 2 * time.Minute, MaxResolution: time.Hour}},
+	})
+
+	// The tier keeps snapshots older than the base tier's MaxResolution, and
+	// only the base snapshot after the last consolidated one is pending.
+	tier := stats.History.tiers[0]
+	if tier.elems.len() != 2 || !tier.elems.at(0).Born.Equal(consolidated.Born) || len(tier.pending) != 1 {
+		t.Fatalf("unexpected tier after load: %d elems, %d pending", tier.elems.len(), len(tier.pending))
+	}
+
+	stats.RequestsTotal.Add(3)
+	stats.History.add(stats)
+	stats.Close()
+
+	elems, tiers, err := store.Load()
+	if err != nil || len(elems) != 3 {
+		t.Fatalf("expected 3 saved elems, got %+v, %v", elems, err)
+	}
+
+	// The unknown hour tier is no longer saved.
+	if len(tiers) != 1 || tiers[0].Resolution != 2*time.Minute || len(tiers[0].Elems) != 3 {
+		t.Fatalf("unexpected saved tiers: %+v", tiers)
+	}
+	if last := tiers[0].Elems[2]; last.RequestsDiff != 8 {
+		t.Fatalf("expected consolidated diff of 8, got %+v", last)
+	}
+
+	// Files from before tiers were persisted can still be loaded.
+	if err = os.WriteFile(store.Path, []byte(`{"version":1,"elems":[{"Born":"2020-01-01T00:00:00Z"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if elems, tiers, err = store.Load(); err != nil || len(elems) != 1 || len(tiers) != 0 {
+		t.Fatalf("unexpected version 1 load: %+v, %+v, %v", elems, tiers, err)
+	}
+}
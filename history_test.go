@@ -0,0 +1,137 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package httpstat
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHistoryElem(t *testing.T) {
+	stats := New("history_"+strconv.Itoa(time.Now().Nanosecond()), &HistoryOptions{
+		Enabled:    true,
+		Resolution: time.Hour,
+	})
+	defer stats.Close()
+
+	serve := func(status int, dur time.Duration) {
+		rr := NewResponseRecorder(httptest.NewRecorder())
+		rr.WriteHeader(status)
+		_, _ = rr.Write([]byte("hello"))
+		stats.update(rr, dur, 10)
+	}
+
+	serve(200, time.Millisecond)
+	stats.History.add(stats)
+
+	for i := 0; i < 98; i++ {
+		serve(200, 10*time.Millisecond)
+	}
+	serve(503, time.Second)
+	serve(404, time.Second)
+
+	stats.begin()
+	stats.begin()
+	stats.InFlight.Add(-2)
+	stats.History.add(stats)
+
+	elems := stats.History.Elems()
+	elem := elems[1]
+
+	if last, ok := stats.History.Last(); !ok || !last.Born.Equal(elem.Born) {
+		t.Fatalf("expected last snapshot to be born at %s, got %s", elem.Born, last.Born)
+	}
+
+	if elem.RequestsDiff != 100 || elem.ErrorsDiff != 1 || elem.BytesInDiff != 1000 || elem.BytesOutDiff != 500 {
+		t.Fatalf("unexpected deltas: %+v", elem)
+	}
+
+	if elem.StatusDiff[200] != 98 || elem.StatusDiff[503] != 1 || elem.StatusClassDiff(4) != 1 || elem.StatusTotal[200] != 99 {
+		t.Fatalf("unexpected status deltas: %v", elem.StatusDiff)
+	}
+
+	if elem.InFlightPeak != 2 {
+		t.Fatalf("expected in-flight peak of 2, got %d", elem.InFlightPeak)
+	}
+
+	wantRPS := 100 / elem.Born.Sub(elems[0].Born).Seconds()
+	if math.Abs(elem.RPS-wantRPS) > 1e-6 {
+		t.Fatalf("expected fractional rps of %f, got %f", wantRPS, elem.RPS)
+	}
+
+	if math.Abs(elem.LatencyMean-0.0298) > 1e-6 {
+		t.Fatalf("unexpected mean latency %f", elem.LatencyMean)
+	}
+
+	// Bucket boundaries are ~41% apart, so allow for that much error.
+	within := func(got, want float64) bool { return got >= want/1.42 && got <= want*1.42 }
+	if !within(elem.LatencyP50, 0.01) || !within(elem.LatencyP90, 0.01) || !within(elem.LatencyP99, 1) {
+		t.Fatalf("unexpected percentiles p50=%f p90=%f p99=%f", elem.LatencyP50, elem.LatencyP90, elem.LatencyP99)
+	}
+
+	// Returned snapshots don't share their maps and slices with the stored ones.
+	elem.StatusDiff[200] = 0
+	elem.StatusTotal[200] = 0
+	elem.LatencyCounts[0] = -1
+	stats.History.Range(time.Time{}, time.Time{}, func(e HistoryElem) bool {
+		e.StatusDiff[200] = 0
+		return true
+	})
+	if last, _ := stats.History.Last(); last.StatusDiff[200] != 98 || last.StatusTotal[200] != 99 || last.LatencyCounts[0] < 0 {
+		t.Fatalf("stored snapshot was modified: %+v", last)
+	}
+}
+
+func TestOnSnapshotRemove(t *testing.T) {
+	stats := New("history_"+strconv.Itoa(time.Now().Nanosecond()), &HistoryOptions{
+		Enabled:    true,
+		Resolution: time.Hour,
+	})
+	defer stats.Close()
+
+	var calls int
+	var remove func()
+	remove = stats.History.OnSnapshot(func(HistoryElem) {
+		calls++
+		remove()
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		stats.History.add(stats)
+		stats.History.add(stats)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out: hook removing itself deadlocked")
+	}
+
+	if calls != 1 {
+		t.Fatalf("expected removed hook to be called once, got %d", calls)
+	}
+}
+
+func TestRecordInFlight(t *testing.T) {
+	stats := New("inflight_"+strconv.Itoa(time.Now().Nanosecond()), nil)
+	defer stats.Close()
+
+	handler := stats.Record(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v := stats.InFlight.Value(); v != 1 {
+			t.Errorf("expected 1 in-flight request, got %d", v)
+		}
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if v := stats.InFlight.Value(); v != 0 {
+		t.Fatalf("expected 0 in-flight requests, got %d", v)
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package httpstat
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// HistoryTier is an additional, coarser, tier of history snapshots, which
+// allows for longer retention without storing every snapshot. For example,
+// with a 5 second Resolution, a tier with a Resolution of 1 minute would
+// consolidate every 12 snapshots into one.
+type HistoryTier struct {
+	// Resolution is the time between each consolidated snapshot. It is
+	// rounded to a multiple of HistoryOptions.Resolution.
+	Resolution time.Duration
+	// MaxResolution is how far back the tier stores snapshots. Defaults to
+	// 60 times Resolution.
+	MaxResolution time.Duration
+}
+
+type historyTier struct {
+	opts    HistoryTier
+	size    int // Amount of base snapshots per consolidated snapshot.
+	pending []HistoryElem
+	elems   *elemRing
+}
+
+// setup allocates the base ring buffer, and validates Opts.Tiers, sorting
+// them from finest to coarsest.
+func (h *History) setup() {
+	if h.Opts.Clock == nil {
+		h.Opts.Clock = systemClock{}
+	}
+
+	h.started = h.Opts.Clock.Now()
+	h.done = make(chan struct{})
+	h.elems = newElemRing(h.Opts.Resolution, h.Opts.MaxResolution)
+
+	tiers := append([]HistoryTier(nil), h.Opts.Tiers...)
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].Resolution < tiers[j].Resolution })
+
+	h.tiers = nil
+	for i := range tiers {
+		size := int((tiers[i].Resolution + h.Opts.Resolution/2) / h.Opts.Resolution)
+		if size < 2 {
+			// Would be the same as (or finer than) the base tier.
+			continue
+		}
+
+		tiers[i].Resolution = time.Duration(size) * h.Opts.Resolution
+		if tiers[i].MaxResolution < tiers[i].Resolution {
+			tiers[i].MaxResolution = 60 * tiers[i].Resolution
+		}
+
+		h.tiers = append(h.tiers, &historyTier{
+			opts:    tiers[i],
+			size:    size,
+			pending: make([]HistoryElem, 0, size),
+			elems:   newElemRing(tiers[i].Resolution, tiers[i].MaxResolution),
+		})
+	}
+
+	h.Opts.Tiers = nil
+	for _, tier := range h.tiers {
+		h.Opts.Tiers = append(h.Opts.Tiers, tier.opts)
+	}
+}
+
+// rollup adds a new base snapshot to each tier, consolidating the pending
+// snapshots once there are enough. Must be called with mu held.
+func (h *History) rollup(elem HistoryElem) {
+	for _, tier := range h.tiers {
+		tier.add(elem)
+	}
+}
+
+// add adds a new base snapshot to the tier, consolidating the pending
+// snapshots once there are enough.
+func (t *historyTier) add(elem HistoryElem) {
+	t.pending = append(t.pending, elem)
+	if len(t.pending) < t.size {
+		return
+	}
+
+	t.elems.push(consolidate(t.pending, t.opts.Resolution/time.Duration(t.size)))
+	t.pending = t.pending[:0]
+}
+
+// Tiers returns the resolution and retention of each tier of history,
+// starting with the base tier (HistoryOptions.Resolution and MaxResolution),
+// from finest to coarsest.
+func (h *History) Tiers() []HistoryTier {
+	tiers := []HistoryTier{{Resolution: h.Opts.Resolution, MaxResolution: h.Opts.MaxResolution}}
+	return append(tiers, h.Opts.Tiers...)
+}
+
+// ElemsFor returns the snapshots within the last window, from the finest
+// tier which retains at least window. If no tier retains that long, the
+// coarsest tier is used.
+func (h *History) ElemsFor(window time.Duration) []HistoryElem {
+	if window <= 0 {
+		return h.Elems()
+	}
+
+	return h.Query(HistoryQuery{From: h.Opts.Clock.Now().Add(-window)})
+}
+
+// consolidate merges multiple consecutive snapshots, each covering resolution,
+// into one. Totals are taken from the newest snapshot, deltas (including
+// latency bucket counts, from which the percentiles are recalculated) are
+// summed, and peaks use the maximum. If any snapshot is missing its latency
+// bucket counts (e.g. loaded from an older store), the maximum of each
+// percentile is used instead.
+func consolidate(elems []HistoryElem, resolution time.Duration) HistoryElem {
+	out := elems[len(elems)-1]
+	out.RequestsDiff = 0
+	out.TimeDiff = 0
+	out.ErrorsDiff = 0
+	out.BytesInDiff = 0
+	out.BytesOutDiff = 0
+	out.StatusDiff = make(map[int]int64)
+	out.LatencyCounts = nil
+
+	exact := true
+	counts := make([]int64, numLatencyBuckets+1)
+
+	for i := range elems {
+		out.RequestsDiff += elems[i].RequestsDiff
+		out.TimeDiff += elems[i].TimeDiff
+		out.ErrorsDiff += elems[i].ErrorsDiff
+		out.BytesInDiff += elems[i].BytesInDiff
+		out.BytesOutDiff += elems[i].BytesOutDiff
+
+		for code, diff := range elems[i].StatusDiff {
+			out.StatusDiff[code] += diff
+		}
+
+		if elems[i].RequestsDiff > 0 && len(elems[i].LatencyCounts) != len(counts) {
+			exact = false
+		}
+		for j := 0; j < len(elems[i].LatencyCounts) && j < len(counts); j++ {
+			counts[j] += elems[i].LatencyCounts[j]
+		}
+
+		out.LatencyP50 = math.Max(out.LatencyP50, elems[i].LatencyP50)
+		out.LatencyP90 = math.Max(out.LatencyP90, elems[i].LatencyP90)
+		out.LatencyP99 = math.Max(out.LatencyP99, elems[i].LatencyP99)
+		if elems[i].InFlightPeak > out.InFlightPeak {
+			out.InFlightPeak = elems[i].InFlightPeak
+		}
+	}
+
+	// The snapshots may only cover part of the consolidated interval (e.g.
+	// the newest, while it's still in progress), so the rate is over the time
+	// which they do cover.
+	covered := elems[len(elems)-1].Born.Sub(elems[0].Born) + resolution
+	out.RPS = float64(out.RequestsDiff) / covered.Seconds()
+
+	out.LatencyMean = 0
+	if out.RequestsDiff > 0 {
+		out.LatencyMean = out.TimeDiff / float64(out.RequestsDiff)
+
+		if exact {
+			out.LatencyCounts = counts
+			out.LatencyP50 = percentile(counts, 0.5)
+			out.LatencyP90 = percentile(counts, 0.9)
+			out.LatencyP99 = percentile(counts, 0.99)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,100 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package httpstat
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistoryTiers(t *testing.T) {
+	h := &History{Opts: HistoryOptions{
+		Enabled:       true,
+		Resolution:    5 * time.Second,
+		MaxResolution: 30 * time.Second,
+		Tiers: []HistoryTier{
+			{Resolution: time.Hour},
+			{Resolution: 14 * time.Second, MaxResolution: 10 * time.Minute},
+		},
+	}}
+	h.setup()
+
+	tiers := h.Tiers()
+	if len(tiers) != 3 || tiers[1].Resolution != 15*time.Second || tiers[2].MaxResolution != 60*time.Hour {
+		t.Fatalf("unexpected tiers: %+v", tiers)
+	}
+
+	now := time.Now()
+	h.mu.Lock()
+	for i := 0; i < 9; i++ {
+		elem := HistoryElem{
+			Born:          now.Add(time.Duration(i-8) * 5 * time.Second),
+			RequestsTotal: int64(i+1) * 10,
+			RequestsDiff:  10,
+			TimeDiff:      1,
+		}
+		h.elems.push(elem)
+		h.rollup(elem)
+	}
+	h.mu.Unlock()
+
+	elems := h.ElemsFor(5 * time.Minute)
+	if len(elems) != 3 {
+		t.Fatalf("expected 3 consolidated elems, got %d", len(elems))
+	}
+
+	if elems[2].RequestsTotal != 90 || elems[2].RequestsDiff != 30 || elems[2].TimeDiff != 3 || elems[2].RPS != 2 {
+		t.Fatalf("unexpected consolidated elem: %+v", elems[2])
+	}
+
+	if elems = h.ElemsFor(12 * time.Second); len(elems) != 3 || elems[2].RequestsDiff != 10 {
+		t.Fatalf("expected 3 base elems, got %+v", elems)
+	}
+}
+
+func TestConsolidateLatency(t *testing.T) {
+	fast := make([]int64, numLatencyBuckets+1)
+	fast[10] = 99
+	slow := make([]int64, numLatencyBuckets+1)
+	slow[30] = 1
+
+	elems := []HistoryElem{
+		{RequestsDiff: 99, LatencyCounts: fast, LatencyP99: percentile(fast, 0.99)},
+		{RequestsDiff: 1, LatencyCounts: slow, LatencyP50: percentile(slow, 0.5)},
+	}
+
+	out := consolidate(elems, time.Second)
+	if out.LatencyCounts[10] != 99 || out.LatencyCounts[30] != 1 {
+		t.Fatalf("unexpected merged counts: %v", out.LatencyCounts)
+	}
+
+	// The slow request shouldn't drag the median up, as the maximum would.
+	if out.LatencyP50 > latencyBuckets[10] || out.LatencyP99 > latencyBuckets[10] {
+		t.Fatalf("unexpected recalculated percentiles: p50=%f p99=%f", out.LatencyP50, out.LatencyP99)
+	}
+
+	// Without counts (e.g. loaded from an older store), fall back to the
+	// maximum.
+	elems[1].LatencyCounts = nil
+	if out = consolidate(elems, time.Second); out.LatencyCounts != nil || out.LatencyP50 != elems[1].LatencyP50 {
+		t.Fatalf("expected fallback to maximum percentiles, got %+v", out)
+	}
+}
+
+func TestLatencyBucketsCopy(t *testing.T) {
+	buckets := LatencyBuckets()
+	buckets[0] = 1000
+	_ = append(buckets, 2000)
+
+	if LatencyBuckets()[0] == 1000 || len(LatencyBuckets()) != numLatencyBuckets {
+		t.Fatal("expected modifying the returned buckets to have no effect")
+	}
+
+	var l latencyHistogram
+	l.observe(time.Hour)
+	if counts := l.snapshot(); counts[numLatencyBuckets] != 1 {
+		t.Fatalf("expected slow request in the overflow bucket, got %v", counts)
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+// Package influx encodes httpstat metrics into the InfluxDB line protocol,
+// and can push them to an InfluxDB (or compatible, e.g. VictoriaMetrics)
+// /write endpoint.
+package influx
+
+import (
+	"bytes"
+	"expvar"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lrstanley/httpstat"
+)
+
+// Encoder encodes httpstat metrics into line protocol.
+type Encoder struct {
+	// Prefix is prepended to all measurement names. Defaults to "httpstat",
+	// resulting in measurements such as "httpstat_history".
+	Prefix string
+	// Tags are additional tags added to every point (e.g. host).
+	Tags map[string]string
+}
+
+func (enc *Encoder) measurement(name string) string {
+	prefix := enc.Prefix
+	if prefix == "" {
+		prefix = "httpstat"
+	}
+	return prefix + "_" + name
+}
+
+// EncodeStats encodes the current totals of stats, as of t. Status totals
+// are encoded as separate points, tagged with the status.
+func (enc *Encoder) EncodeStats(buf *bytes.Buffer, stats *httpstat.HTTPStats, t time.Time) {
+	uptime, _ := strconv.ParseInt(stats.Uptime.String(), 10, 64)
+	tags := enc.tags(stats.Namespace(), nil)
+
+	enc.point(buf, enc.measurement("stats"), tags, []field{
+		{"requests_total", intValue(stats.RequestsTotal.Value())},
+		{"request_errors_total", intValue(stats.RequestErrorsTotal.Value())},
+		{"time_total", floatValue(stats.TimeTotal.Value())},
+		{"bytes_in_total", intValue(stats.BytesInTotal.Value())},
+		{"bytes_out_total", intValue(stats.BytesOutTotal.Value())},
+		{"uptime", intValue(uptime)},
+	}, t)
+
+	stats.StatusTotal.Do(func(kv expvar.KeyValue) {
+		enc.point(
+			buf, enc.measurement("status"),
+			enc.tags(stats.Namespace(), map[string]string{"status": kv.Key}),
+			[]field{{"count", kv.Value.String() + "i"}}, t,
+		)
+	})
+}
+
+// EncodeElem encodes a single History snapshot. The amount of requests for
+// each status since the previous snapshot are encoded as separate points,
+// tagged with the status.
+func (enc *Encoder) EncodeElem(buf *bytes.Buffer, namespace string, elem httpstat.HistoryElem) {
+	enc.point(buf, enc.measurement("history"), enc.tags(namespace, nil), []field{
+		{"requests_total", intValue(elem.RequestsTotal)},
+		{"requests", intValue(elem.RequestsDiff)},
+		{"request_errors_total", intValue(elem.RequestErrors)},
+		{"request_errors", intValue(elem.ErrorsDiff)},
+		{"rps", floatValue(elem.RPS)},
+		{"time_total", floatValue(elem.TimeTotal)},
+		{"time", floatValue(elem.TimeDiff)},
+		{"bytes_in", intValue(elem.BytesInDiff)},
+		{"bytes_out", intValue(elem.BytesOutDiff)},
+		{"latency_mean", floatValue(elem.LatencyMean)},
+		{"latency_p50", floatValue(elem.LatencyP50)},
+		{"latency_p90", floatValue(elem.LatencyP90)},
+		{"latency_p99", floatValue(elem.LatencyP99)},
+		{"in_flight_peak", intValue(elem.InFlightPeak)},
+	}, elem.Born)
+
+	codes := make([]int, 0, len(elem.StatusDiff))
+	for code := range elem.StatusDiff {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+
+	for _, code := range codes {
+		enc.point(
+			buf, enc.measurement("history_status"),
+			enc.tags(namespace, map[string]string{"status": strconv.Itoa(code)}),
+			[]field{{"count", intValue(elem.StatusDiff[code])}}, elem.Born,
+		)
+	}
+}
+
+// EncodeEvent encodes a single request, tagged with the method, status and
+// route (if any). Note that the path is not included as a tag, as it is
+// usually unbounded.
+func (enc *Encoder) EncodeEvent(buf *bytes.Buffer, namespace string, event httpstat.RequestEvent) {
+	tags := map[string]string{
+		"method": event.Method,
+		"status": strconv.Itoa(event.Status),
+	}
+	if event.Route != "" {
+		tags["route"] = event.Route
+	}
+
+	enc.point(buf, enc.measurement("request"), enc.tags(namespace, tags), []field{
+		{"duration", floatValue(event.Duration.Seconds())},
+		{"bytes_in", intValue(int64(event.BytesIn))},
+		{"bytes_out", intValue(int64(event.BytesOut))},
+	}, event.Start)
+}
+
+type field struct {
+	key   string
+	value string
+}
+
+func intValue(v int64) string     { return strconv.FormatInt(v, 10) + "i" }
+func floatValue(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+
+// tags merges the namespace, Encoder.Tags and extra, sorted by key as
+// recommended by InfluxDB.
+func (enc *Encoder) tags(namespace string, extra map[string]string) [][2]string {
+	merged := make(map[string]string, len(enc.Tags)+len(extra)+1)
+	for k, v := range enc.Tags {
+		merged[k] = v
+	}
+	for k, v := range extra {
+		merged[k] = v
+	}
+	if namespace != "" {
+		merged["namespace"] = namespace
+	}
+
+	out := make([][2]string, 0, len(merged))
+	for k, v := range merged {
+		// Empty tag values aren't allowed.
+		if v != "" {
+			out = append(out, [2]string{k, v})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i][0] < out[j][0] })
+
+	return out
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+func (enc *Encoder) point(buf *bytes.Buffer, measurement string, tags [][2]string, fields []field, t time.Time) {
+	buf.WriteString(measurementEscaper.Replace(measurement))
+	for _, tag := range tags {
+		buf.WriteByte(',')
+		buf.WriteString(tagEscaper.Replace(tag[0]))
+		buf.WriteByte('=')
+		buf.WriteString(tagEscaper.Replace(tag[1]))
+	}
+
+	buf.WriteByte(' ')
+	for i, f := range fields {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(tagEscaper.Replace(f.key))
+		buf.WriteByte('=')
+		buf.WriteString(f.value)
+	}
+
+	buf.WriteByte(' ')
+	buf.WriteString(strconv.FormatInt(t.UnixNano(), 10))
+	buf.WriteByte('\n')
+}
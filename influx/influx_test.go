@@ -0,0 +1,149 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package influx
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lrstanley/httpstat"
+)
+
+func TestEncoder(t *testing.T) {
+	enc := &Encoder{Tags: map[string]string{"host": "web 1"}}
+	born := time.Unix(1500000000, 0)
+
+	buf := &bytes.Buffer{}
+	enc.EncodeElem(buf, "api", httpstat.HistoryElem{
+		Born: born, TimeTotal: 1.5, TimeDiff: 0.5, RequestsTotal: 10, RequestsDiff: 4, RPS: 2,
+		StatusDiff: map[int]int64{500: 1, 200: 3},
+	})
+	enc.EncodeEvent(buf, "", httpstat.RequestEvent{
+		Method: "GET", Route: "/users/{id}", Status: 200, Duration: 250 * time.Millisecond,
+		BytesIn: 10, BytesOut: 20, Start: born,
+	})
+
+	want := "httpstat_history,host=web\\ 1,namespace=api requests_total=10i,requests=4i,request_errors_total=0i,request_errors=0i,rps=2,time_total=1.5,time=0.5,bytes_in=0i,bytes_out=0i,latency_mean=0,latency_p50=0,latency_p90=0,latency_p99=0,in_flight_peak=0i 1500000000000000000\n" +
+		"httpstat_history_status,host=web\\ 1,namespace=api,status=200 count=3i 1500000000000000000\n" +
+		"httpstat_history_status,host=web\\ 1,namespace=api,status=500 count=1i 1500000000000000000\n" +
+		"httpstat_request,host=web\\ 1,method=GET,route=/users/{id},status=200 duration=0.25,bytes_in=10i,bytes_out=20i 1500000000000000000\n"
+
+	if buf.String() != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestWriterRetry(t *testing.T) {
+	var attempts int32
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	w := &Writer{URL: srv.URL + "/write?db=test", RetryWait: time.Millisecond}
+	if err := w.Write(context.Background(), []byte("m v=1i 1\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	if attempts != 2 || string(body) != "m v=1i 1\n" {
+		t.Fatalf("unexpected attempts %d, body %q", attempts, body)
+	}
+
+	// Client errors shouldn't be retried.
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		http.Error(w, "bad line", http.StatusBadRequest)
+	})
+	if err := w.Write(context.Background(), []byte("bad")); err == nil || attempts != 3 {
+		t.Fatalf("expected a single failed attempt, got %d attempts, err %v", attempts, err)
+	}
+}
+
+func TestExporterRetainsFailedPoints(t *testing.T) {
+	var fail int32 = 1
+	var body []byte
+	var onFail func()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.LoadInt32(&fail) {
+		case 1:
+			if onFail != nil {
+				onFail()
+			}
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		case 2:
+			http.Error(w, "bad line", http.StatusBadRequest)
+			return
+		}
+
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	stats := httpstat.New("influx_"+strconv.Itoa(time.Now().Nanosecond()), &httpstat.HistoryOptions{
+		Enabled:    true,
+		Resolution: time.Hour,
+	})
+	defer stats.Close()
+
+	e, err := NewExporter(stats, &Writer{URL: srv.URL, Retries: -1}, &ExporterOptions{MaxBatchSize: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	add := func(points string) {
+		e.mu.Lock()
+		e.pending.WriteString(points)
+		e.truncate()
+		e.mu.Unlock()
+	}
+
+	add("a 1\nb 2\n")
+	if err := e.flush(context.Background()); err == nil || strings.Contains(err.Error(), "dropped") {
+		t.Fatalf("expected error without dropped points, got %v", err)
+	}
+
+	// Points added while writing are kept after the failed points, and only
+	// the oldest point no longer fits.
+	onFail = func() { add("c 3\n") }
+	if err := e.flush(context.Background()); err == nil || !strings.Contains(err.Error(), "dropped 1 points") {
+		t.Fatalf("expected error with 1 dropped point, got %v", err)
+	}
+
+	atomic.StoreInt32(&fail, 0)
+	if err := e.flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "b 2\nc 3\n" {
+		t.Fatalf("unexpected body %q", body)
+	}
+
+	// Rejected points would be rejected again, so they're dropped.
+	atomic.StoreInt32(&fail, 2)
+	add("d 4\n")
+	if err := e.flush(context.Background()); err == nil || !strings.Contains(err.Error(), "dropped 1 points") {
+		t.Fatalf("expected rejected point to be dropped, got %v", err)
+	}
+	if e.pending.Len() != 0 {
+		t.Fatalf("expected no pending points, got %q", e.pending.String())
+	}
+}
@@ -0,0 +1,306 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package influx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lrstanley/httpstat"
+)
+
+// Writer posts batches of line protocol to an InfluxDB /write endpoint.
+type Writer struct {
+	// URL is the full write URL, including any query parameters, e.g.
+	// "http://localhost:8086/write?db=httpstat".
+	URL string
+	// Header are additional headers to include in each request (e.g. for
+	// authentication).
+	Header http.Header
+	// Client is the http client used to send requests. If nil, a client
+	// with a 10 second timeout is used.
+	Client *http.Client
+	// Retries is the amount of times a failed write is retried, for network
+	// errors, 429 and 5xx responses. Defaults to 3. Use a negative value to
+	// disable retries.
+	Retries int
+	// RetryWait is the initial delay between retries, which is doubled after
+	// each attempt. Defaults to 1 second.
+	RetryWait time.Duration
+}
+
+// WriteError is returned by Writer.Write when InfluxDB responds with an
+// unsuccessful status.
+type WriteError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *WriteError) Error() string {
+	return fmt.Sprintf("influx: write returned status %d: %s", e.StatusCode, e.Message)
+}
+
+// Retryable reports whether the write may succeed when retried, which is the
+// case for 429 and 5xx responses. Other responses (e.g. 400 for invalid line
+// protocol) will fail again.
+func (e *WriteError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// Write posts body, which should be one or more points in line protocol. If
+// InfluxDB responds with an unsuccessful status, the error is a *WriteError.
+func (w *Writer) Write(ctx context.Context, body []byte) error {
+	retries := w.Retries
+	if retries == 0 {
+		retries = 3
+	}
+	wait := w.RetryWait
+	if wait <= 0 {
+		wait = time.Second
+	}
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		retry, err = w.write(ctx, body)
+		if err == nil || !retry || attempt >= retries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+func (w *Writer) write(ctx context.Context, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	for key, values := range w.Header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	client := w.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 300 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return false, nil
+	}
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	werr := &WriteError{StatusCode: resp.StatusCode, Message: string(bytes.TrimSpace(msg))}
+	return werr.Retryable(), werr
+}
+
+// ExporterOptions are the options used when creating a new Exporter.
+type ExporterOptions struct {
+	Encoder Encoder
+	// Events also encodes each request (see Encoder.EncodeEvent), which are
+	// batched and sent with the next History snapshot.
+	Events bool
+	// MaxBatchSize is the maximum size, in bytes, of pending points, which
+	// includes points kept after a failed write (for network errors, 429 and
+	// 5xx responses), to be retried with the next snapshot. When exceeded,
+	// the oldest points are dropped. Defaults to 5MB.
+	MaxBatchSize int
+	// ErrorLog is used to log write errors. If nil, the log package's
+	// standard logger is used.
+	ErrorLog *log.Logger
+}
+
+// Exporter writes the current stats and the new snapshot on each History
+// snapshot, using a Writer.
+type Exporter struct {
+	stats  *httpstat.HTTPStats
+	writer *Writer
+	opts   ExporterOptions
+	remove func()
+	hook   *httpstat.RequestHook
+
+	mu      sync.Mutex
+	pending bytes.Buffer
+	wake    chan struct{}
+	closer  chan struct{}
+	done    chan struct{}
+}
+
+// NewExporter returns a new Exporter. History must be enabled. Make sure
+// Exporter.Close is called when it is no longer needed.
+func NewExporter(stats *httpstat.HTTPStats, writer *Writer, opts *ExporterOptions) (*Exporter, error) {
+	if !stats.History.Opts.Enabled {
+		return nil, errors.New("influx: requested HTTPStats has history disabled")
+	}
+
+	if opts == nil {
+		opts = &ExporterOptions{}
+	}
+
+	e := &Exporter{
+		stats:  stats,
+		writer: writer,
+		opts:   *opts,
+		wake:   make(chan struct{}, 1),
+		closer: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	if e.opts.MaxBatchSize <= 0 {
+		e.opts.MaxBatchSize = 5 << 20
+	}
+
+	go e.run()
+	if e.opts.Events {
+		e.hook = stats.OnRequestAsync(e.event, 0)
+	}
+	e.remove = stats.History.OnSnapshot(e.snapshot)
+
+	return e, nil
+}
+
+// Close stops the Exporter, and attempts to write any pending points.
+func (e *Exporter) Close() error {
+	e.remove()
+	if e.hook != nil {
+		e.hook.Remove()
+	}
+
+	close(e.closer)
+	<-e.done
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return e.flush(ctx)
+}
+
+func (e *Exporter) event(event httpstat.RequestEvent) {
+	e.mu.Lock()
+	e.opts.Encoder.EncodeEvent(&e.pending, e.stats.Namespace(), event)
+	e.truncate()
+	e.mu.Unlock()
+}
+
+func (e *Exporter) snapshot(elem httpstat.HistoryElem) {
+	e.mu.Lock()
+	e.opts.Encoder.EncodeElem(&e.pending, e.stats.Namespace(), elem)
+	e.opts.Encoder.EncodeStats(&e.pending, e.stats, elem.Born)
+	e.truncate()
+	e.mu.Unlock()
+
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// truncate drops the oldest points if pending is over MaxBatchSize, and
+// returns the amount of points dropped. Must be called with mu held.
+func (e *Exporter) truncate() (dropped int) {
+	over := e.pending.Len() - e.opts.MaxBatchSize
+	if over <= 0 {
+		return 0
+	}
+
+	b := e.pending.Bytes()
+	if i := bytes.IndexByte(b[over-1:], '\n'); i >= 0 {
+		return bytes.Count(e.pending.Next(over+i), []byte{'\n'})
+	}
+
+	dropped = bytes.Count(b, []byte{'\n'})
+	e.pending.Reset()
+	return dropped
+}
+
+func (e *Exporter) run() {
+	defer close(e.done)
+
+	// Cancel any in-flight write (and its retries) when closing.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-e.closer
+		cancel()
+	}()
+
+	for {
+		select {
+		case <-e.closer:
+			return
+		case <-e.wake:
+			if err := e.flush(ctx); err != nil {
+				e.logf("influx: error writing points: %s", err)
+			}
+		}
+	}
+}
+
+func (e *Exporter) flush(ctx context.Context) error {
+	e.mu.Lock()
+	body := append([]byte(nil), e.pending.Bytes()...)
+	e.pending.Reset()
+	e.mu.Unlock()
+
+	if len(body) == 0 {
+		return nil
+	}
+
+	err := e.writer.Write(ctx, body)
+	if err == nil {
+		return nil
+	}
+
+	// Points which were rejected would be rejected again, and would hold up
+	// all points after them.
+	var werr *WriteError
+	if errors.As(err, &werr) && !werr.Retryable() {
+		return fmt.Errorf("%w (dropped %d points)", err, bytes.Count(body, []byte{'\n'}))
+	}
+
+	// Keep the failed points for the next write, ahead of any points added
+	// since, only dropping the oldest if that's over MaxBatchSize.
+	e.mu.Lock()
+	body = append(body, e.pending.Bytes()...)
+	e.pending.Reset()
+	e.pending.Write(body)
+	dropped := e.truncate()
+	e.mu.Unlock()
+
+	if dropped > 0 {
+		return fmt.Errorf("%w (dropped %d points)", err, dropped)
+	}
+	return err
+}
+
+func (e *Exporter) logf(format string, args ...interface{}) {
+	if e.opts.ErrorLog != nil {
+		e.opts.ErrorLog.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
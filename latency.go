@@ -0,0 +1,101 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package httpstat
+
+import (
+	"math"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// numLatencyBuckets is the amount of latency bucket upper bounds, see
+// LatencyBuckets.
+const numLatencyBuckets = 40
+
+// latencyBuckets are the upper bounds (in seconds) of each latency bucket.
+var latencyBuckets = func() []float64 {
+	buckets := make([]float64, numLatencyBuckets)
+	for i := range buckets {
+		buckets[i] = 0.0001 * math.Pow(math.Sqrt2, float64(i))
+	}
+	return buckets
+}()
+
+// LatencyBuckets returns the upper bounds (in seconds) of the buckets used to
+// track the latency distribution of requests, which is used to estimate
+// percentiles. They grow exponentially from 100µs to roughly 75s, with an
+// additional final bucket for anything slower. The returned slice is a copy.
+func LatencyBuckets() []float64 {
+	return append([]float64(nil), latencyBuckets...)
+}
+
+// LatencyCounts returns the cumulative amount of requests within each of
+// LatencyBuckets, with an extra trailing bucket for requests slower than the
+// largest bucket.
+func (s *HTTPStats) LatencyCounts() []int64 {
+	return s.latency.snapshot()
+}
+
+// EstimatePercentile estimates the p (0-1) percentile latency, in seconds,
+// from the amount of requests within each latency bucket (e.g. the difference
+// between two calls to HTTPStats.LatencyCounts).
+func EstimatePercentile(counts []int64, p float64) float64 {
+	return percentile(counts, p)
+}
+
+// latencyHistogram is a lock-free, cumulative, histogram of request
+// latencies, using latencyBuckets.
+type latencyHistogram struct {
+	counts [numLatencyBuckets + 1]int64
+}
+
+func (l *latencyHistogram) observe(dur time.Duration) {
+	i := sort.SearchFloat64s(latencyBuckets, dur.Seconds())
+	atomic.AddInt64(&l.counts[i], 1)
+}
+
+// snapshot returns the current cumulative count of each bucket.
+func (l *latencyHistogram) snapshot() []int64 {
+	out := make([]int64, len(l.counts))
+	for i := range l.counts {
+		out[i] = atomic.LoadInt64(&l.counts[i])
+	}
+	return out
+}
+
+// percentile estimates the p (0-1) percentile from per-bucket counts, by
+// interpolating linearly within the bucket the percentile falls in.
+func percentile(counts []int64, p float64) float64 {
+	var total int64
+	for _, c := range counts {
+		total += c
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := p * float64(total)
+	var seen int64
+	for i, c := range counts {
+		if c == 0 || float64(seen+c) < rank {
+			seen += c
+			continue
+		}
+
+		lower := 0.0
+		if i > 0 {
+			lower = latencyBuckets[i-1]
+		}
+		if i >= len(latencyBuckets) {
+			// Nothing to interpolate with, for the overflow bucket.
+			return lower
+		}
+
+		return lower + (latencyBuckets[i]-lower)*(rank-float64(seen))/float64(c)
+	}
+
+	return latencyBuckets[len(latencyBuckets)-1]
+}
@@ -0,0 +1,216 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+// Package otelhttpstat registers httpstat metrics as OpenTelemetry
+// instruments. Totals are exported as observable counters, and request
+// durations and sizes as histograms, using the attribute names from the HTTP
+// semantic conventions.
+package otelhttpstat
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"strconv"
+
+	"github.com/lrstanley/httpstat"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const instrumentationName = "github.com/lrstanley/httpstat/otelhttpstat"
+
+// Attribute keys, from the HTTP semantic conventions.
+const (
+	AttrMethod     = attribute.Key("http.request.method")
+	AttrStatusCode = attribute.Key("http.response.status_code")
+	AttrRoute      = attribute.Key("http.route")
+	// AttrNamespace is the namespace of the HTTPStats, if not empty.
+	AttrNamespace = attribute.Key("httpstat.namespace")
+)
+
+// Options are the options used when creating a new Bridge.
+type Options struct {
+	// MeterProvider is used to create the meter. Defaults to the global
+	// provider (see otel.GetMeterProvider).
+	MeterProvider metric.MeterProvider
+	// Attributes are additional attributes added to every measurement.
+	Attributes []attribute.KeyValue
+}
+
+// Bridge is a registered set of instruments for an HTTPStats.
+type Bridge struct {
+	stats *httpstat.HTTPStats
+	attrs []attribute.KeyValue
+	reg   metric.Registration
+	hook  *httpstat.RequestHook
+
+	duration metric.Float64Histogram
+	reqSize  metric.Int64Histogram
+	respSize metric.Int64Histogram
+}
+
+// New registers the instruments for stats. Make sure Bridge.Close is called
+// when they are no longer needed.
+func New(stats *httpstat.HTTPStats, opts *Options) (*Bridge, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	provider := opts.MeterProvider
+	if provider == nil {
+		provider = otel.GetMeterProvider()
+	}
+	meter := provider.Meter(instrumentationName)
+
+	b := &Bridge{stats: stats, attrs: append([]attribute.KeyValue(nil), opts.Attributes...)}
+	if ns := stats.Namespace(); ns != "" {
+		b.attrs = append(b.attrs, AttrNamespace.String(ns))
+	}
+
+	var err error
+	b.duration, err = meter.Float64Histogram(
+		"http.server.request.duration",
+		metric.WithDescription("Duration of HTTP server requests."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(0.005, 0.01, 0.025, 0.05, 0.075, 0.1, 0.25, 0.5, 0.75, 1, 2.5, 5, 7.5, 10),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	b.reqSize, err = meter.Int64Histogram(
+		"http.server.request.body.size",
+		metric.WithDescription("Size of HTTP server request bodies."),
+		metric.WithUnit("By"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	b.respSize, err = meter.Int64Histogram(
+		"http.server.response.body.size",
+		metric.WithDescription("Size of HTTP server response bodies."),
+		metric.WithUnit("By"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	requests, err := meter.Int64ObservableCounter(
+		"httpstat.requests",
+		metric.WithDescription("Total number of HTTP requests, by status code."),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	errorsTotal, err := meter.Int64ObservableCounter(
+		"httpstat.request.errors",
+		metric.WithDescription("Total number of HTTP requests which resulted in a 5xx status code."),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	timeTotal, err := meter.Float64ObservableCounter(
+		"httpstat.request.time",
+		metric.WithDescription("Total time spent handling HTTP requests."),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	bytesIn, err := meter.Int64ObservableCounter(
+		"httpstat.request.bytes",
+		metric.WithDescription("Total approximate size of HTTP requests."),
+		metric.WithUnit("By"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	bytesOut, err := meter.Int64ObservableCounter(
+		"httpstat.response.bytes",
+		metric.WithDescription("Total size of HTTP response bodies."),
+		metric.WithUnit("By"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	uptime, err := meter.Int64ObservableGauge(
+		"httpstat.uptime",
+		metric.WithDescription("Time since the HTTPStats was created."),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	b.reg, err = meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		attrs := metric.WithAttributes(b.attrs...)
+
+		stats.StatusTotal.Do(func(kv expvar.KeyValue) {
+			code, _ := strconv.Atoi(kv.Key)
+			value, _ := strconv.ParseInt(kv.Value.String(), 10, 64)
+			o.ObserveInt64(requests, value, attrs, metric.WithAttributes(AttrStatusCode.Int(code)))
+		})
+
+		o.ObserveInt64(errorsTotal, stats.RequestErrorsTotal.Value(), attrs)
+		o.ObserveFloat64(timeTotal, stats.TimeTotal.Value(), attrs)
+		o.ObserveInt64(bytesIn, stats.BytesInTotal.Value(), attrs)
+		o.ObserveInt64(bytesOut, stats.BytesOutTotal.Value(), attrs)
+
+		seconds, _ := strconv.ParseInt(stats.Uptime.String(), 10, 64)
+		o.ObserveInt64(uptime, seconds, attrs)
+		return nil
+	}, requests, errorsTotal, timeTotal, bytesIn, bytesOut, uptime)
+	if err != nil {
+		return nil, err
+	}
+
+	b.hook = stats.OnRequest(b.record)
+	return b, nil
+}
+
+// Close unregisters the instruments callback, and stops recording requests.
+func (b *Bridge) Close() error {
+	b.hook.Remove()
+	return b.reg.Unregister()
+}
+
+func (b *Bridge) record(event httpstat.RequestEvent) {
+	attrs := make([]attribute.KeyValue, 0, len(b.attrs)+3)
+	attrs = append(attrs, b.attrs...)
+	attrs = append(attrs, AttrMethod.String(normalizeMethod(event.Method)), AttrStatusCode.Int(event.Status))
+	if event.Route != "" {
+		attrs = append(attrs, AttrRoute.String(event.Route))
+	}
+
+	ctx := context.Background()
+	set := metric.WithAttributeSet(attribute.NewSet(attrs...))
+
+	b.duration.Record(ctx, event.Duration.Seconds(), set)
+	if event.ContentLength >= 0 {
+		b.reqSize.Record(ctx, event.ContentLength, set)
+	}
+	b.respSize.Record(ctx, int64(event.BytesOut), set)
+}
+
+// normalizeMethod returns "_OTHER" for non-standard methods, as recommended
+// by the semantic conventions, to keep cardinality bounded.
+func normalizeMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return "_OTHER"
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package otelhttpstat
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lrstanley/httpstat"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestBridge(t *testing.T) {
+	stats := httpstat.New("otel_"+strconv.Itoa(time.Now().Nanosecond()), nil)
+	defer stats.Close()
+	stats.RouteKey = func(r *http.Request) string { return "/items/{id}" }
+
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	b, err := New(stats, &Options{MeterProvider: provider})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	handler := stats.Record(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/items/1", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PURGE", "/items/2", strings.NewReader("hello")))
+
+	var rm metricdata.ResourceMetrics
+	if err = reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+
+	metrics := map[string]metricdata.Metrics{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			metrics[m.Name] = m
+		}
+	}
+
+	requests, ok := metrics["httpstat.requests"].Data.(metricdata.Sum[int64])
+	if !ok || len(requests.DataPoints) != 1 || requests.DataPoints[0].Value != 2 {
+		t.Fatalf("unexpected httpstat.requests: %+v", metrics["httpstat.requests"])
+	}
+	if code, _ := requests.DataPoints[0].Attributes.Value(AttrStatusCode); code.AsInt64() != 500 {
+		t.Fatalf("unexpected status code attribute: %v", code)
+	}
+
+	errorsTotal, ok := metrics["httpstat.request.errors"].Data.(metricdata.Sum[int64])
+	if !ok || len(errorsTotal.DataPoints) != 1 || errorsTotal.DataPoints[0].Value != 2 {
+		t.Fatalf("unexpected httpstat.request.errors: %+v", metrics["httpstat.request.errors"])
+	}
+
+	duration, ok := metrics["http.server.request.duration"].Data.(metricdata.Histogram[float64])
+	if !ok || len(duration.DataPoints) != 2 {
+		t.Fatalf("unexpected http.server.request.duration: %+v", metrics["http.server.request.duration"])
+	}
+
+	// Only the body is counted, not the request line and headers.
+	reqSize, ok := metrics["http.server.request.body.size"].Data.(metricdata.Histogram[int64])
+	if !ok || len(reqSize.DataPoints) != 2 || reqSize.DataPoints[0].Sum+reqSize.DataPoints[1].Sum != 5 {
+		t.Fatalf("unexpected http.server.request.body.size: %+v", metrics["http.server.request.body.size"])
+	}
+
+	methods := map[string]bool{}
+	for _, dp := range duration.DataPoints {
+		method, _ := dp.Attributes.Value(AttrMethod)
+		methods[method.AsString()] = true
+
+		if route, _ := dp.Attributes.Value(AttrRoute); route != attribute.StringValue("/items/{id}") {
+			t.Fatalf("unexpected route attribute: %v", route.Emit())
+		}
+	}
+
+	if !methods["GET"] || !methods["_OTHER"] {
+		t.Fatalf("unexpected method attributes: %v", methods)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package httpstat
+
+import "time"
+
+// elemRing is a fixed-capacity ring buffer of snapshots, ordered from oldest
+// to newest. When full, pushing a new snapshot overwrites the oldest. It is
+// not safe for concurrent use.
+type elemRing struct {
+	buf   []HistoryElem
+	start int
+	size  int
+}
+
+// newElemRing returns a ring with enough capacity to hold maxAge worth of
+// snapshots taken every resolution.
+func newElemRing(resolution, maxAge time.Duration) *elemRing {
+	capacity := int((maxAge+resolution-1)/resolution) + 1
+	return &elemRing{buf: make([]HistoryElem, capacity)}
+}
+
+func (r *elemRing) len() int { return r.size }
+
+// at returns the i'th snapshot, where 0 is the oldest.
+func (r *elemRing) at(i int) *HistoryElem {
+	return &r.buf[(r.start+i)%len(r.buf)]
+}
+
+func (r *elemRing) last() (elem HistoryElem, ok bool) {
+	if r.size == 0 {
+		return elem, false
+	}
+	return *r.at(r.size - 1), true
+}
+
+func (r *elemRing) push(elem HistoryElem) {
+	if r.size < len(r.buf) {
+		r.buf[(r.start+r.size)%len(r.buf)] = elem
+		r.size++
+		return
+	}
+
+	r.buf[r.start] = elem
+	r.start = (r.start + 1) % len(r.buf)
+}
+
+// dropBefore removes all snapshots born before cutoff.
+func (r *elemRing) dropBefore(cutoff time.Time) {
+	for r.size > 0 && r.buf[r.start].Born.Before(cutoff) {
+		r.buf[r.start] = HistoryElem{}
+		r.start = (r.start + 1) % len(r.buf)
+		r.size--
+	}
+}
+
+// search returns the index of the first snapshot born at or after t.
+func (r *elemRing) search(t time.Time) int {
+	lo, hi := 0, r.size
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if r.at(mid).Born.Before(t) {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
+
+// appendTo appends copies of the snapshots born within [from, to] to dst.
+// Zero times are treated as unbounded.
+func (r *elemRing) appendTo(dst []HistoryElem, from, to time.Time) []HistoryElem {
+	i := 0
+	if !from.IsZero() {
+		i = r.search(from)
+	}
+
+	for ; i < r.size; i++ {
+		elem := r.at(i)
+		if !to.IsZero() && elem.Born.After(to) {
+			break
+		}
+		dst = append(dst, elem.clone())
+	}
+	return dst
+}
@@ -0,0 +1,86 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package httpstat
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestElemRing(t *testing.T) {
+	r := newElemRing(time.Second, 3*time.Second)
+	if len(r.buf) != 4 {
+		t.Fatalf("expected capacity of 4, got %d", len(r.buf))
+	}
+
+	base := time.Unix(1500000000, 0)
+	for i := 0; i < 6; i++ {
+		r.push(HistoryElem{Born: base.Add(time.Duration(i) * time.Second), RequestsTotal: int64(i)})
+	}
+
+	elems := r.appendTo(nil, time.Time{}, time.Time{})
+	if len(elems) != 4 || elems[0].RequestsTotal != 2 || elems[3].RequestsTotal != 5 {
+		t.Fatalf("unexpected elems after wrapping: %+v", elems)
+	}
+
+	elems = r.appendTo(nil, base.Add(3*time.Second), base.Add(4*time.Second))
+	if len(elems) != 2 || elems[0].RequestsTotal != 3 || elems[1].RequestsTotal != 4 {
+		t.Fatalf("unexpected ranged elems: %+v", elems)
+	}
+
+	r.dropBefore(base.Add(4 * time.Second))
+	if last, ok := r.last(); r.len() != 2 || !ok || last.RequestsTotal != 5 {
+		t.Fatalf("unexpected ring after drop: len %d, last %+v", r.len(), last)
+	}
+}
+
+func TestHistoryConcurrentElems(t *testing.T) {
+	stats := New("ring_"+strconv.Itoa(time.Now().Nanosecond()), &HistoryOptions{
+		Enabled:       true,
+		Resolution:    time.Hour,
+		MaxResolution: 10 * time.Hour,
+	})
+	defer stats.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			stats.RequestsTotal.Add(1)
+			stats.History.add(stats)
+		}
+	}()
+
+	var held [][]HistoryElem
+	for i := 0; i < 100; i++ {
+		held = append(held, stats.History.Elems())
+	}
+	wg.Wait()
+
+	// Previously returned copies must not be modified by later snapshots.
+	for _, elems := range held {
+		for i := 1; i < len(elems); i++ {
+			if elems[i].RequestsTotal < elems[i-1].RequestsTotal {
+				t.Fatalf("returned elems were modified: %+v", elems)
+			}
+		}
+	}
+
+	if n := len(stats.History.Elems()); n != 11 {
+		t.Fatalf("expected history to be capped at 11 elems, got %d", n)
+	}
+
+	var count int
+	stats.History.Range(time.Time{}, time.Time{}, func(elem HistoryElem) bool {
+		count++
+		return count < 5
+	})
+	if count != 5 {
+		t.Fatalf("expected Range to stop after 5 elems, got %d", count)
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package httpstat
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// Attribute names used by SlogAccessLog. These can be used with
+// SlogOptions.Fields.
+const (
+	FieldMethod     = "method"
+	FieldPath       = "path"
+	FieldURI        = "uri"
+	FieldProto      = "proto"
+	FieldHost       = "host"
+	FieldRoute      = "route"
+	FieldStatus     = "status"
+	FieldDuration   = "duration"
+	FieldBytesIn    = "bytes_in"
+	FieldBytesOut   = "bytes_out"
+	FieldRemoteAddr = "remote_addr"
+	FieldStart      = "start"
+	FieldHeaders    = "headers"
+)
+
+// DefaultSlogFields are the fields logged by SlogAccessLog when
+// SlogOptions.Fields is empty.
+var DefaultSlogFields = []string{
+	FieldMethod, FieldPath, FieldRoute, FieldStatus, FieldDuration,
+	FieldBytesIn, FieldBytesOut, FieldRemoteAddr,
+}
+
+// DefaultRedactedHeaders are the headers which are redacted by SlogAccessLog
+// when SlogOptions.RedactHeaders is nil.
+var DefaultRedactedHeaders = []string{
+	"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie",
+}
+
+// SlogOptions are the options used by SlogAccessLog.
+type SlogOptions struct {
+	// Message is the log message used for each request. Defaults to
+	// "request".
+	Message string
+	// Levels maps a status class (e.g. 4 for 4xx) to the level requests in
+	// that class are logged at. Classes not in the map default to
+	// slog.LevelError for 5xx, slog.LevelWarn for 4xx, and slog.LevelInfo
+	// for everything else.
+	Levels map[int]slog.Level
+	// Fields are the names of the attributes to include (see the Field*
+	// constants). Defaults to DefaultSlogFields.
+	Fields []string
+	// Headers are the request headers to include when the "headers" field is
+	// enabled. Use "*" to include all headers.
+	Headers []string
+	// RedactHeaders are headers which have their values replaced with
+	// "[REDACTED]". Defaults to DefaultRedactedHeaders if nil.
+	RedactHeaders []string
+}
+
+func (o *SlogOptions) level(status int) slog.Level {
+	class := status / 100
+
+	if level, ok := o.Levels[class]; ok {
+		return level
+	}
+
+	switch class {
+	case 5:
+		return slog.LevelError
+	case 4:
+		return slog.LevelWarn
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// SlogAccessLog returns a request hook which logs one record per request to
+// logger, e.g.:
+//
+//	stats.OnRequest(httpstat.SlogAccessLog(slog.Default(), nil))
+//
+// Use HTTPStats.OnRequestAsync instead if the logger's handler may block.
+func SlogAccessLog(logger *slog.Logger, opts *SlogOptions) func(RequestEvent) {
+	// Defaults are applied to a copy, so the caller's options can be reused.
+	o := SlogOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	opts = &o
+
+	if opts.Message == "" {
+		opts.Message = "request"
+	}
+	if len(opts.Fields) == 0 {
+		opts.Fields = DefaultSlogFields
+	}
+	if opts.RedactHeaders == nil {
+		opts.RedactHeaders = DefaultRedactedHeaders
+	}
+
+	redact := make(map[string]bool, len(opts.RedactHeaders))
+	for _, name := range opts.RedactHeaders {
+		redact[http.CanonicalHeaderKey(name)] = true
+	}
+
+	allHeaders := false
+	for _, name := range opts.Headers {
+		if name == "*" {
+			allHeaders = true
+		}
+	}
+
+	return func(e RequestEvent) {
+		ctx := context.Background()
+		level := opts.level(e.Status)
+
+		if !logger.Enabled(ctx, level) {
+			return
+		}
+
+		attrs := make([]slog.Attr, 0, len(opts.Fields))
+		for _, field := range opts.Fields {
+			switch field {
+			case FieldMethod:
+				attrs = append(attrs, slog.String(field, e.Method))
+			case FieldPath:
+				attrs = append(attrs, slog.String(field, e.Path))
+			case FieldURI:
+				attrs = append(attrs, slog.String(field, e.RequestURI))
+			case FieldProto:
+				attrs = append(attrs, slog.String(field, e.Proto))
+			case FieldHost:
+				attrs = append(attrs, slog.String(field, e.Host))
+			case FieldRoute:
+				attrs = append(attrs, slog.String(field, e.Route))
+			case FieldStatus:
+				attrs = append(attrs, slog.Int(field, e.Status))
+			case FieldDuration:
+				attrs = append(attrs, slog.Duration(field, e.Duration))
+			case FieldBytesIn:
+				attrs = append(attrs, slog.Int(field, e.BytesIn))
+			case FieldBytesOut:
+				attrs = append(attrs, slog.Int(field, e.BytesOut))
+			case FieldRemoteAddr:
+				attrs = append(attrs, slog.String(field, e.RemoteAddr))
+			case FieldStart:
+				attrs = append(attrs, slog.Time(field, e.Start))
+			case FieldHeaders:
+				var headers []slog.Attr
+				if allHeaders {
+					for name := range e.Header {
+						headers = append(headers, headerAttr(e.Header, name, redact))
+					}
+				} else {
+					for _, name := range opts.Headers {
+						if _, ok := e.Header[http.CanonicalHeaderKey(name)]; ok {
+							headers = append(headers, headerAttr(e.Header, name, redact))
+						}
+					}
+				}
+				attrs = append(attrs, slog.Attr{Key: field, Value: slog.GroupValue(headers...)})
+			}
+		}
+
+		logger.LogAttrs(ctx, level, opts.Message, attrs...)
+	}
+}
+
+func headerAttr(header http.Header, name string, redact map[string]bool) slog.Attr {
+	name = http.CanonicalHeaderKey(name)
+
+	if redact[name] {
+		return slog.String(name, "[REDACTED]")
+	}
+
+	return slog.String(name, strings.Join(header[name], ", "))
+}
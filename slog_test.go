@@ -0,0 +1,61 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package httpstat
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSlogAccessLog(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := slog.New(slog.NewJSONHandler(buf, nil))
+
+	opts := &SlogOptions{
+		Fields:  []string{FieldMethod, FieldStatus, FieldHeaders},
+		Headers: []string{"authorization", "user-agent"},
+	}
+	log := SlogAccessLog(logger, opts)
+
+	if opts.Message != "" || opts.RedactHeaders != nil {
+		t.Fatalf("defaults were applied to the caller's options: %+v", opts)
+	}
+
+	log(RequestEvent{
+		Method:   "GET",
+		Status:   503,
+		Duration: time.Millisecond,
+		Header: http.Header{
+			"Authorization": {"Bearer secret"},
+			"User-Agent":    {"curl/8.0"},
+			"Accept":        {"*/*"},
+		},
+	})
+
+	var out struct {
+		Level   string
+		Method  string
+		Status  int
+		Path    *string
+		Headers map[string]string
+	}
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if out.Level != "ERROR" || out.Method != "GET" || out.Status != 503 || out.Path != nil {
+		t.Fatalf("unexpected record: %s", buf.String())
+	}
+
+	want := map[string]string{"Authorization": "[REDACTED]", "User-Agent": "curl/8.0"}
+	if len(out.Headers) != len(want) || out.Headers["Authorization"] != want["Authorization"] ||
+		out.Headers["User-Agent"] != want["User-Agent"] {
+		t.Fatalf("unexpected headers: %v", out.Headers)
+	}
+}
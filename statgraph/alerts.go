@@ -0,0 +1,27 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package statgraph
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/lrstanley/httpstat"
+)
+
+func (rn *renderer) alerts(w http.ResponseWriter, r *http.Request) {
+	alerts := rn.stats.Alerts.Active()
+	if alerts == nil {
+		alerts = []httpstat.Alert{}
+	}
+
+	out, err := json.Marshal(alerts)
+	if err != nil {
+		panic(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(out)
+}
@@ -0,0 +1,539 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+(function() {
+	'use strict';
+
+	var fields = [
+		'born', 'requests_total', 'requests', 'rps', 'errors_total', 'errors',
+		'bytes_in_total', 'bytes_in', 'bytes_out_total', 'bytes_out',
+		'status_1xx', 'status_2xx', 'status_3xx', 'status_4xx', 'status_5xx',
+		'latency_mean', 'latency_p50', 'latency_p90', 'latency_p99',
+		'in_flight_peak', 'latency_counts'
+	];
+
+	var state = {
+		elems: [],
+		window: 0,      // In milliseconds.
+		zoom: null,     // When zoomed, {from, to} in milliseconds.
+		source: null,   // EventSource, while live.
+		refresh: null,  // Refresh timer, when not live.
+		loaded: Date.now()
+	};
+
+	// query returns the query string for the given parameters, including the
+	// namespace when multiple are available.
+	function query(params) {
+		if (config.multiple) params.ns = config.namespace;
+
+		var out = [];
+		for (var key in params) out.push(encodeURIComponent(key) + '=' + encodeURIComponent(params[key]));
+		return '?' + out.join('&');
+	}
+
+	function parseDuration(v) {
+		var units = {ms: 1, s: 1000, m: 60000, h: 3600000};
+		var total = 0, re = /([\d.]+)(ms|s|m|h)/g, m;
+		while ((m = re.exec(v)) !== null) total += parseFloat(m[1]) * units[m[2]];
+		return total;
+	}
+
+	function formatDuration(s) {
+		if (s >= 1) return s.toFixed(2) + 's';
+		if (s >= 0.001) return (s * 1000).toFixed(1) + 'ms';
+		return (s * 1000000).toFixed(0) + 'µs';
+	}
+
+	function formatBytes(b) {
+		var units = ['B', 'KiB', 'MiB', 'GiB', 'TiB'];
+		var i = 0;
+		for (; Math.abs(b) >= 1024 && i < units.length - 1; i++) b /= 1024;
+		return (i > 0 ? b.toFixed(1) : b.toFixed(0)) + ' ' + units[i];
+	}
+
+	function formatUptime(secs) {
+		var units = [['d', 86400], ['h', 3600], ['m', 60], ['s', 1]];
+		var out = [];
+		for (var i = 0; i < units.length && out.length < 2; i++) {
+			var n = Math.floor(secs / units[i][1]);
+			if (n > 0 || (out.length === 0 && units[i][0] === 's')) {
+				out.push(n + units[i][0]);
+				secs -= n * units[i][1];
+			} else if (out.length > 0) {
+				break;
+			}
+		}
+		return out.join(' ');
+	}
+
+	function formatTime(t, withDate) {
+		var d = new Date(t);
+		var time = d.toTimeString().substr(0, 8);
+		return withDate ? d.toISOString().substr(5, 5) + ' ' + time : time;
+	}
+
+	// fromSnapshot converts a snapshot from /events (a HistoryElem) into the
+	// same form as returned by /history.
+	function fromSnapshot(e) {
+		var elem = {
+			born: e.Born,
+			requests_total: e.RequestsTotal,
+			requests: e.RequestsDiff,
+			rps: e.RPS,
+			errors_total: e.RequestErrors,
+			errors: e.ErrorsDiff,
+			bytes_in_total: e.BytesInTotal,
+			bytes_in: e.BytesInDiff,
+			bytes_out_total: e.BytesOutTotal,
+			bytes_out: e.BytesOutDiff,
+			latency_mean: e.LatencyMean,
+			latency_p50: e.LatencyP50,
+			latency_p90: e.LatencyP90,
+			latency_p99: e.LatencyP99,
+			in_flight_peak: e.InFlightPeak,
+			latency_counts: e.LatencyCounts
+		};
+
+		for (var c = 1; c <= 5; c++) elem['status_' + c + 'xx'] = 0;
+		for (var code in e.StatusDiff) elem['status_' + Math.floor(code / 100) + 'xx'] += e.StatusDiff[code];
+		return elem;
+	}
+
+	function prepare(elem) {
+		elem.t = Date.parse(elem.born);
+		return elem;
+	}
+
+	// elapsed returns the amount of seconds covered by elems[i].
+	function elapsed(elems, i) {
+		if (i > 0 && elems[i].t > elems[i - 1].t) return (elems[i].t - elems[i - 1].t) / 1000;
+		if (elems[i].rps > 0) return elems[i].requests / elems[i].rps;
+		return config.resolution / 1000;
+	}
+
+	var charts = [
+		{id: 'rps', format: function(v) { return v.toFixed(1); }, series: [
+			{name: 'req/sec', color: '#0074d9', fill: true, value: function(e) { return e.rps; }}
+		]},
+		{id: 'requests', format: function(v) { return v.toFixed(0); }, series: [
+			{name: 'requests', color: '#d96500', fill: true, value: function(e) { return e.requests; }}
+		]},
+		{id: 'latency', format: formatDuration, series: [
+			{name: 'p99', color: '#d90074', value: function(e) { return e.latency_p99; }},
+			{name: 'p90', color: '#d96500', value: function(e) { return e.latency_p90; }},
+			{name: 'p50', color: '#0074d9', value: function(e) { return e.latency_p50; }},
+			{name: 'mean', color: '#333333', dash: true, value: function(e) { return e.latency_mean; }}
+		]},
+		{id: 'bytes', format: function(v) { return formatBytes(v) + '/s'; }, series: [
+			{name: 'in', color: '#0074d9', fill: true, value: function(e, i, elems) { return e.bytes_in / elapsed(elems, i); }},
+			{name: 'out', color: '#d96500', fill: true, value: function(e, i, elems) { return e.bytes_out / elapsed(elems, i); }}
+		]},
+		{id: 'status_codes', stacked: true, format: function(v) { return v.toFixed(0); }, series: [
+			{name: '1xx', color: '#6e808b', value: function(e) { return e.status_1xx; }},
+			{name: '2xx', color: '#00d965', value: function(e) { return e.status_2xx; }},
+			{name: '3xx', color: '#0074d9', value: function(e) { return e.status_3xx; }},
+			{name: '4xx', color: '#d96500', value: function(e) { return e.status_4xx; }},
+			{name: '5xx', color: '#d90074', value: function(e) { return e.status_5xx; }}
+		]},
+		{id: 'errors', format: function(v) { return v.toFixed(1) + '%'; }, series: [
+			{name: 'error rate', color: '#d90074', fill: true, value: function(e) {
+				return e.requests > 0 ? e.errors / e.requests * 100 : 0;
+			}}
+		]},
+		{id: 'heatmap', heatmap: true}
+	];
+
+	var pad = {left: 10, right: 80, top: 10, bottom: 20};
+
+	// scale returns the functions to translate times and values into pixels.
+	function scale(canvas, t0, t1, min, max) {
+		var w = canvas.width, h = canvas.height;
+		return {
+			x: function(t) { return pad.left + (t - t0) / (t1 - t0) * (w - pad.left - pad.right); },
+			y: function(v) { return h - pad.bottom - (v - min) / (max - min) * (h - pad.top - pad.bottom); },
+			t: function(x) { return t0 + (x - pad.left) / (w - pad.left - pad.right) * (t1 - t0); }
+		};
+	}
+
+	function drawAxes(ctx, canvas, t0, t1, labels) {
+		var w = canvas.width, h = canvas.height;
+
+		ctx.fillStyle = '#333';
+		ctx.strokeStyle = '#333';
+		ctx.lineWidth = 1;
+		ctx.font = '11px sans-serif';
+		ctx.beginPath();
+		ctx.moveTo(pad.left, h - pad.bottom + 0.5);
+		ctx.lineTo(w - pad.right, h - pad.bottom + 0.5);
+		ctx.lineTo(w - pad.right + 0.5, pad.top);
+		ctx.stroke();
+
+		ctx.textAlign = 'left';
+		for (var i = 0; i < labels.length; i++) ctx.fillText(labels[i].text, w - pad.right + 5, labels[i].y + 4);
+
+		var withDate = t1 - t0 > 86400000;
+		ctx.fillText(formatTime(t0, withDate), pad.left, h - 5);
+		ctx.textAlign = 'right';
+		ctx.fillText(formatTime(t1, withDate), w - pad.right, h - 5);
+	}
+
+	function drawLines(chart, canvas, ctx, elems) {
+		var values = [], max = 0;
+		for (var j = 0; j < chart.series.length; j++) {
+			values[j] = [];
+			for (var i = 0; i < elems.length; i++) {
+				var v = chart.series[j].value(elems[i], i, elems) || 0;
+				if (chart.stacked && j > 0) v += values[j - 1][i];
+				values[j].push(v);
+				max = Math.max(max, v);
+			}
+		}
+		if (max === 0) max = 1;
+
+		var t0 = elems[0].t, t1 = elems[elems.length - 1].t;
+		var s = chart.scale = scale(canvas, t0, t1, 0, max);
+		chart.values = values;
+
+		// Stacked series are drawn top down, so each covers the one above.
+		for (var j = chart.series.length - 1; j >= 0; j--) {
+			var series = chart.series[j];
+			var line = function() {
+				ctx.beginPath();
+				ctx.moveTo(s.x(elems[0].t), s.y(values[j][0]));
+				for (var i = 1; i < elems.length; i++) ctx.lineTo(s.x(elems[i].t), s.y(values[j][i]));
+			};
+
+			if (series.fill || chart.stacked) {
+				line();
+				ctx.lineTo(s.x(t1), s.y(0));
+				ctx.lineTo(s.x(t0), s.y(0));
+				ctx.closePath();
+
+				// Stacked areas are opaque, so they don't blend together.
+				if (chart.stacked) {
+					ctx.fillStyle = '#fff';
+					ctx.fill();
+				}
+				ctx.globalAlpha = chart.stacked ? 0.5 : 0.25;
+				ctx.fillStyle = series.color;
+				ctx.fill();
+				ctx.globalAlpha = 1;
+			}
+
+			line();
+			ctx.setLineDash(series.dash ? [5, 5] : []);
+			ctx.strokeStyle = series.color;
+			ctx.lineWidth = 1.5;
+			ctx.stroke();
+			ctx.setLineDash([]);
+		}
+
+		drawAxes(ctx, canvas, t0, t1, [
+			{y: s.y(max), text: chart.format(max)},
+			{y: s.y(max / 2), text: chart.format(max / 2)},
+			{y: s.y(0), text: chart.format(0)}
+		]);
+
+		if (chart.series.length > 1) {
+			ctx.textAlign = 'left';
+			for (var j = 0; j < chart.series.length; j++) {
+				ctx.fillStyle = chart.series[j].color;
+				ctx.fillRect(pad.left + 8, pad.top + 6 + j * 14, 10, 10);
+				ctx.fillStyle = '#333';
+				ctx.fillText(chart.series[j].name, pad.left + 22, pad.top + 15 + j * 14);
+			}
+		}
+	}
+
+	function bucketBound(k) {
+		if (k <= 0) return '0s';
+		if (k > config.latency_buckets.length) return '+Inf';
+		return formatDuration(config.latency_buckets[k - 1]);
+	}
+
+	function drawHeatmap(chart, canvas, ctx, elems) {
+		var lo = Infinity, hi = 0, max = 0;
+		for (var i = 0; i < elems.length; i++) {
+			var counts = elems[i].latency_counts || [];
+			for (var k = 0; k < counts.length; k++) {
+				if (!counts[k]) continue;
+				lo = Math.min(lo, k);
+				hi = Math.max(hi, k + 1);
+				max = Math.max(max, counts[k]);
+			}
+		}
+		if (max === 0) {
+			lo = 0;
+			hi = 10;
+		}
+
+		var t0 = elems[0].t - elapsed(elems, 0) * 1000, t1 = elems[elems.length - 1].t;
+		var s = chart.scale = scale(canvas, t0, t1, lo, hi);
+
+		for (var i = 0; i < elems.length; i++) {
+			var counts = elems[i].latency_counts || [];
+			var x0 = s.x(elems[i].t - elapsed(elems, i) * 1000), x1 = s.x(elems[i].t);
+			for (var k = lo; k < hi; k++) {
+				if (!counts[k]) continue;
+
+				// Log scaled, from dark purple (few) to yellow (many).
+				var f = Math.log(1 + counts[k]) / Math.log(1 + max);
+				ctx.fillStyle = 'hsl(' + (280 - f * 220) + ', 70%, ' + (25 + f * 35) + '%)';
+				ctx.fillRect(x0, s.y(k + 1), Math.max(x1 - x0, 1), s.y(k) - s.y(k + 1));
+			}
+		}
+
+		drawAxes(ctx, canvas, t0, t1, [
+			{y: s.y(hi), text: bucketBound(hi)},
+			{y: s.y((lo + hi) / 2), text: bucketBound(Math.round((lo + hi) / 2))},
+			{y: s.y(lo), text: bucketBound(lo)}
+		]);
+	}
+
+	function draw(chart) {
+		var canvas = document.getElementById(chart.id);
+		var ctx = canvas.getContext('2d');
+		ctx.clearRect(0, 0, canvas.width, canvas.height);
+
+		var elems = state.elems;
+		chart.scale = null;
+		if (elems.length < 2) return;
+
+		if (chart.heatmap) {
+			drawHeatmap(chart, canvas, ctx, elems);
+		} else {
+			drawLines(chart, canvas, ctx, elems);
+		}
+
+		if (chart.selection) {
+			ctx.fillStyle = 'rgba(0, 116, 217, 0.15)';
+			ctx.fillRect(Math.min(chart.selection[0], chart.selection[1]), pad.top,
+				Math.abs(chart.selection[1] - chart.selection[0]), canvas.height - pad.top - pad.bottom);
+		}
+	}
+
+	function redraw() {
+		for (var i = 0; i < charts.length; i++) draw(charts[i]);
+		updateTiles();
+	}
+
+	function updateTiles() {
+		var last = state.elems[state.elems.length - 1];
+		if (!last || state.zoom) return;
+
+		document.getElementById('tile-requests').textContent = last.requests_total;
+		document.getElementById('tile-error-rate').textContent =
+			(last.requests_total > 0 ? last.errors_total / last.requests_total * 100 : 0).toFixed(2) + '%';
+		document.getElementById('tile-bytes-in').textContent = formatBytes(last.bytes_in_total);
+		document.getElementById('tile-bytes-out').textContent = formatBytes(last.bytes_out_total);
+		document.getElementById('tile-in-flight').textContent = last.in_flight_peak;
+	}
+
+	function updateUptime() {
+		var secs = config.uptime + Math.floor((Date.now() - state.loaded) / 1000);
+		document.getElementById('tile-uptime').textContent = formatUptime(secs);
+	}
+
+	function setStatus(text) {
+		document.getElementById('status').textContent = text;
+	}
+
+	function loadAlerts() {
+		var req = new XMLHttpRequest();
+		req.onload = function() {
+			var container = document.getElementById('alerts');
+			container.innerHTML = '';
+
+			var alerts = JSON.parse(req.responseText);
+			for (var i = 0; i < alerts.length; i++) {
+				var el = document.createElement('div');
+				el.className = alerts[i].state;
+				el.textContent = alerts[i].state + ': ' + alerts[i].rule +
+					' (value: ' + alerts[i].value.toFixed(2) + ', threshold: ' + alerts[i].threshold + ')';
+				container.appendChild(el);
+			}
+		};
+		req.open('GET', './alerts' + query({r: Date.now()}));
+		req.send();
+	}
+
+	function stopLive() {
+		if (state.source) state.source.close();
+		clearTimeout(state.refresh);
+		state.source = null;
+		state.refresh = null;
+	}
+
+	// startLive streams new snapshots from /events, when viewing the base tier.
+	// Coarser tiers are periodically reloaded instead.
+	function startLive() {
+		if (state.window > config.max_resolution) {
+			setStatus('updated ' + formatTime(Date.now()));
+			state.refresh = setTimeout(load, 60000);
+			return;
+		}
+
+		var last = state.elems[state.elems.length - 1];
+		var params = {window: state.window + 'ms'};
+		if (last) params.since = last.born;
+
+		var source = state.source = new EventSource('./events' + query(params));
+		source.addEventListener('open', function() { setStatus('live'); });
+		source.addEventListener('snapshot', function(ev) {
+			var elem = prepare(fromSnapshot(JSON.parse(ev.data)));
+			var elems = state.elems;
+			if (elems.length > 0 && elem.t <= elems[elems.length - 1].t) return;
+
+			elems.push(elem);
+			while (elems.length > 0 && elems[0].t < elem.t - state.window) elems.shift();
+
+			// Avoid redrawing for each snapshot of a backlog.
+			clearTimeout(source.redraw);
+			source.redraw = setTimeout(function() {
+				redraw();
+				loadAlerts();
+			}, 50);
+		});
+		source.onerror = function() { setStatus('disconnected, retrying...'); };
+	}
+
+	// load fetches the current window (or zoomed range) from /history.
+	function load() {
+		stopLive();
+		setStatus('loading...');
+
+		var width = document.getElementById('rps').width - pad.left - pad.right;
+		var params = {fields: fields.join(',')};
+		var span = state.window;
+		if (state.zoom) {
+			params.from = new Date(state.zoom.from).toISOString();
+			params.to = new Date(state.zoom.to).toISOString();
+			span = state.zoom.to - state.zoom.from;
+		} else {
+			params.window = state.window + 'ms';
+		}
+
+		// No point in fetching more snapshots than there are pixels.
+		var step = Math.floor(span / width / 1000);
+		if (step * 1000 > config.resolution) params.step = step + 's';
+
+		var req = new XMLHttpRequest();
+		req.onload = function() {
+			if (req.status !== 200) {
+				setStatus('error: ' + req.responseText);
+				return;
+			}
+
+			state.elems = JSON.parse(req.responseText).map(prepare);
+			redraw();
+			loadAlerts();
+
+			if (state.zoom) {
+				setStatus('zoomed: ' + formatTime(state.zoom.from, true) + ' - ' + formatTime(state.zoom.to, true));
+			} else {
+				startLive();
+			}
+		};
+		req.onerror = function() { setStatus('error loading history'); };
+		req.open('GET', './history' + query(params));
+		req.send();
+	}
+
+	function zoom(from, to) {
+		state.zoom = from === null ? null : {from: from, to: to};
+		document.getElementById('reset').hidden = state.zoom === null;
+		load();
+	}
+
+	function nearest(t) {
+		var elems = state.elems, best = 0;
+		for (var i = 1; i < elems.length; i++) {
+			if (Math.abs(elems[i].t - t) < Math.abs(elems[best].t - t)) best = i;
+		}
+		return best;
+	}
+
+	function readout(chart, x) {
+		var el = document.getElementById('readout-' + chart.id);
+		if (x === null || !chart.scale) {
+			el.textContent = '';
+			return;
+		}
+
+		var i = nearest(chart.scale.t(x));
+		var elem = state.elems[i];
+		var parts = [formatTime(elem.t, state.window > 86400000)];
+		if (chart.heatmap) {
+			parts.push(elem.requests + ' requests');
+		} else {
+			for (var j = chart.series.length - 1; j >= 0; j--) {
+				var v = chart.series[j].value(elem, i, state.elems) || 0;
+				parts.push(chart.series[j].name + ': ' + chart.format(v));
+			}
+		}
+		el.textContent = parts.join(', ');
+	}
+
+	function attach(chart) {
+		var canvas = document.getElementById(chart.id);
+		var position = function(ev) {
+			var rect = canvas.getBoundingClientRect();
+			return (ev.clientX - rect.left) * canvas.width / rect.width;
+		};
+
+		canvas.addEventListener('mousedown', function(ev) {
+			var x = position(ev);
+			chart.selection = [x, x];
+		});
+		canvas.addEventListener('mousemove', function(ev) {
+			var x = position(ev);
+			readout(chart, x);
+			if (chart.selection) {
+				chart.selection[1] = x;
+				draw(chart);
+			}
+		});
+		canvas.addEventListener('mouseleave', function() {
+			readout(chart, null);
+			if (chart.selection) {
+				chart.selection = null;
+				draw(chart);
+			}
+		});
+		canvas.addEventListener('mouseup', function() {
+			var sel = chart.selection;
+			chart.selection = null;
+			if (!sel || !chart.scale || Math.abs(sel[1] - sel[0]) < 5) {
+				draw(chart);
+				return;
+			}
+
+			var a = chart.scale.t(sel[0]), b = chart.scale.t(sel[1]);
+			zoom(Math.round(Math.min(a, b)), Math.round(Math.max(a, b)));
+		});
+		canvas.addEventListener('dblclick', function() { zoom(null); });
+	}
+
+	var windowSelect = document.getElementById('window');
+	state.window = parseDuration(windowSelect.value) || config.max_resolution;
+	windowSelect.addEventListener('change', function() {
+		state.window = parseDuration(windowSelect.value);
+		zoom(null);
+	});
+
+	var namespaceSelect = document.getElementById('namespace');
+	if (namespaceSelect) {
+		namespaceSelect.addEventListener('change', function() {
+			window.location.search = '?ns=' + encodeURIComponent(namespaceSelect.value);
+		});
+	}
+
+	document.getElementById('reset').addEventListener('click', function() { zoom(null); });
+
+	for (var i = 0; i < charts.length; i++) attach(charts[i]);
+
+	updateUptime();
+	setInterval(updateUptime, 1000);
+	load();
+})();
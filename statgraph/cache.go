@@ -0,0 +1,148 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package statgraph
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// renderCacheSize is the maximum amount of rendered graphs which are kept, for
+// all namespaces combined.
+const renderCacheSize = 64
+
+type renderEntry struct {
+	key         string
+	etag        string
+	contentType string
+	modified    time.Time
+	body        []byte
+}
+
+// renderCache is a least-recently-used cache of rendered graphs.
+type renderCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // Most recently used first.
+}
+
+func newRenderCache() *renderCache {
+	return &renderCache{entries: make(map[string]*list.Element), order: list.New()}
+}
+
+func (c *renderCache) get(key string) (*renderEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	c.order.MoveToFront(el)
+	return el.Value.(*renderEntry), true
+}
+
+func (c *renderCache) add(entry *renderEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[entry.key]; ok {
+		el.Value = entry
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[entry.key] = c.order.PushFront(entry)
+	for c.order.Len() > renderCacheSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*renderEntry).key)
+	}
+}
+
+// cacheRecorder buffers a response, so it can be cached.
+type cacheRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *cacheRecorder) Header() http.Header { return rec.header }
+
+func (rec *cacheRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.body.Write(b)
+}
+
+func (rec *cacheRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+// cached wraps a graph handler, so it's only rendered once per snapshot for
+// the same request. The graph, format, size and options are all part of the
+// request, so the path, query and birth time of the last snapshot are used as
+// the key. Responses include an ETag (a hash of the body) and Last-Modified, so
+// clients can revalidate and receive a 304 while the graph is unchanged.
+func (rn *renderer) cached(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		last, ok := rn.stats.History.Last()
+		if !ok {
+			fn(w, r)
+			return
+		}
+
+		key := fmt.Sprintf("%s\x00%s\x00%s\x00%d",
+			rn.stats.Namespace(), strings.ToLower(r.URL.Path), r.URL.Query().Encode(), last.Born.UnixNano(),
+		)
+
+		entry, ok := rn.handler.cache.get(key)
+		if !ok {
+			rec := &cacheRecorder{header: make(http.Header)}
+			fn(rec, r)
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+
+			// Errors (e.g. invalid options, or graphs which failed to render)
+			// aren't cached.
+			if rec.status != http.StatusOK {
+				for k, v := range rec.header {
+					w.Header()[k] = v
+				}
+				w.WriteHeader(rec.status)
+				_, _ = w.Write(rec.body.Bytes())
+				return
+			}
+
+			hash := fnv.New64a()
+			_, _ = hash.Write(rec.body.Bytes())
+
+			entry = &renderEntry{
+				key:         key,
+				etag:        fmt.Sprintf(`"%x"`, hash.Sum64()),
+				contentType: rec.header.Get("Content-Type"),
+				modified:    last.Born,
+				body:        rec.body.Bytes(),
+			}
+			rn.handler.cache.add(entry)
+		}
+
+		w.Header().Set("Content-Type", entry.contentType)
+		w.Header().Set("ETag", entry.etag)
+		w.Header().Set("Cache-Control", "no-cache")
+		http.ServeContent(w, r, "", entry.modified, bytes.NewReader(entry.body))
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package statgraph
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestRenderCache(t *testing.T) {
+	ts := newTestStats(t)
+	ts.populate(t, 3)
+	h := New(ts.HTTPStats)
+
+	first := get(t, h, "/rps.svg?w=400&h=200")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" || first.Header().Get("Last-Modified") == "" {
+		t.Fatalf("unexpected response: %d %v", first.Code, first.Header())
+	}
+
+	// Query parameter order doesn't matter.
+	second := get(t, h, "/rps.svg?h=200&w=400")
+	if second.Header().Get("ETag") != etag || !bytes.Equal(first.Body.Bytes(), second.Body.Bytes()) {
+		t.Fatal("expected cached response for the same graph")
+	}
+
+	if other := get(t, h, "/rps.svg?w=500&h=200"); other.Header().Get("ETag") == etag {
+		t.Fatal("expected different ETag for a different size")
+	}
+
+	req := httptest.NewRequest("GET", "/rps.svg?w=400&h=200", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Fatalf("expected 304 for matching ETag, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/rps.svg?w=400&h=200", nil)
+	req.Header.Set("If-Modified-Since", first.Header().Get("Last-Modified"))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("expected 304 for If-Modified-Since, got %d", rec.Code)
+	}
+
+	ts.populate(t, 1)
+	if rec := get(t, h, "/rps.svg?w=400&h=200"); rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Fatalf("expected new render after a snapshot, got %d %q", rec.Code, rec.Header().Get("ETag"))
+	}
+
+	// Errors aren't cached.
+	for i := 0; i < 2; i++ {
+		if rec := get(t, h, "/latency.svg?p=42"); rec.Code != http.StatusBadRequest || rec.Header().Get("ETag") != "" {
+			t.Fatalf("unexpected response for invalid request: %d %v", rec.Code, rec.Header())
+		}
+	}
+}
+
+func TestRenderCacheFailures(t *testing.T) {
+	ts := newTestStats(t)
+	ts.populate(t, 1)
+	h := New(ts.HTTPStats)
+
+	// A single snapshot can't be rendered as a line graph.
+	for i := 0; i < 2; i++ {
+		if rec := get(t, h, "/rps.svg"); rec.Code != http.StatusInternalServerError || rec.Header().Get("ETag") != "" {
+			t.Fatalf("expected uncached 500 for failed render, got %d %v", rec.Code, rec.Header())
+		}
+	}
+
+	// Handlers which don't write anything are treated as 200.
+	empty := h.(*handler).renderers[0].cached(func(w http.ResponseWriter, r *http.Request) {})
+	rec := httptest.NewRecorder()
+	empty(rec, httptest.NewRequest("GET", "/empty", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") == "" {
+		t.Fatalf("unexpected response for empty handler: %d %v", rec.Code, rec.Header())
+	}
+
+	// The ETag is derived from the body, not the request.
+	ts.populate(t, 2)
+	first, second := get(t, h, "/rps.svg?w=400"), get(t, h, "/rps.svg?w=400&unused=1")
+	if first.Header().Get("ETag") != second.Header().Get("ETag") {
+		t.Fatalf("expected the same ETag for the same body, got %q and %q", first.Header().Get("ETag"), second.Header().Get("ETag"))
+	}
+}
+
+func TestRenderCacheEviction(t *testing.T) {
+	c := newRenderCache()
+	for i := 0; i < renderCacheSize; i++ {
+		c.add(&renderEntry{key: strconv.Itoa(i)})
+	}
+
+	// Using the oldest entry makes it the most recent.
+	if _, ok := c.get("0"); !ok {
+		t.Fatal("expected entry 0 to be cached")
+	}
+
+	c.add(&renderEntry{key: "new"})
+	if _, ok := c.get("1"); ok {
+		t.Fatal("expected least recently used entry to be evicted")
+	}
+
+	for _, key := range []string{"0", "new", strconv.Itoa(renderCacheSize - 1)} {
+		if _, ok := c.get(key); !ok {
+			t.Fatalf("expected entry %q to be cached", key)
+		}
+	}
+
+	if len(c.entries) != renderCacheSize || c.order.Len() != renderCacheSize {
+		t.Fatalf("expected %d entries, got %d/%d", renderCacheSize, len(c.entries), c.order.Len())
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package statgraph
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/lrstanley/httpstat"
+)
+
+// assets holds the dashboard, so it works without access to the internet.
+//
+//go:embed assets
+var assets embed.FS
+
+var dashboardTemplate = template.Must(template.New("dashboard.html").Funcs(template.FuncMap{
+	"bytes":    func(v int64) string { return formatBytes(float64(v)) },
+	"duration": formatUptime,
+	"percent":  func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) + "%" },
+}).ParseFS(assets, "assets/dashboard.html"))
+
+type dashboardLink struct {
+	Name string
+	URL  string
+}
+
+type dashboardChart struct {
+	ID     string
+	Title  string
+	Height int
+	Links  []dashboardLink
+}
+
+type dashboardWindow struct {
+	Label string
+	Value string
+}
+
+// dashboardConfig is passed to the dashboard's javascript.
+type dashboardConfig struct {
+	Namespace      string    `json:"namespace"`
+	Multiple       bool      `json:"multiple"`
+	Resolution     int64     `json:"resolution"`      // In milliseconds.
+	MaxResolution  int64     `json:"max_resolution"`  // In milliseconds.
+	Uptime         int64     `json:"uptime"`          // In seconds.
+	LatencyBuckets []float64 `json:"latency_buckets"` // In seconds.
+}
+
+type dashboardData struct {
+	Namespace  string
+	Namespaces []string
+	Windows    []dashboardWindow
+	Charts     []dashboardChart
+	Config     dashboardConfig
+
+	Uptime    int64
+	Requests  int64
+	ErrorRate float64
+	BytesIn   int64
+	BytesOut  int64
+	InFlight  int64
+}
+
+func (rn *renderer) dashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	stats := rn.stats
+	uptime, _ := strconv.ParseInt(stats.Uptime.String(), 10, 64)
+
+	data := dashboardData{
+		Namespace: stats.Namespace(),
+		Config: dashboardConfig{
+			Namespace:      stats.Namespace(),
+			Multiple:       len(rn.handler.renderers) > 1,
+			Resolution:     stats.History.Opts.Resolution.Milliseconds(),
+			MaxResolution:  stats.History.Opts.MaxResolution.Milliseconds(),
+			Uptime:         uptime,
+			LatencyBuckets: httpstat.LatencyBuckets(),
+		},
+		Uptime:   uptime,
+		Requests: stats.RequestsTotal.Value(),
+		BytesIn:  stats.BytesInTotal.Value(),
+		BytesOut: stats.BytesOutTotal.Value(),
+		InFlight: stats.InFlight.Value(),
+	}
+
+	if data.Requests > 0 {
+		data.ErrorRate = float64(stats.RequestErrorsTotal.Value()) / float64(data.Requests) * 100
+	}
+
+	for _, other := range rn.handler.renderers {
+		data.Namespaces = append(data.Namespaces, other.stats.Namespace())
+	}
+
+	// Offer each tier's retention as a window, as they're the most precise
+	// for that range.
+	for _, tier := range stats.History.Tiers() {
+		data.Windows = append(data.Windows, dashboardWindow{
+			Label: formatUptime(int64(tier.MaxResolution.Seconds())),
+			Value: tier.MaxResolution.String(),
+		})
+	}
+
+	// Links to the rendered versions of each chart, for the same namespace.
+	link := func(name, path, query string) dashboardLink {
+		if data.Config.Multiple {
+			query += "&ns=" + url.QueryEscape(data.Namespace)
+		}
+		return dashboardLink{Name: name, URL: "./" + path + "?" + query}
+	}
+
+	data.Charts = []dashboardChart{
+		{ID: "rps", Title: "Requests Per Second", Height: 200, Links: []dashboardLink{
+			link("png", "rps.png", "w=800&h=200"), link("svg", "rps.svg", "w=800&h=200"),
+		}},
+		{ID: "requests", Title: "Request Count", Height: 200, Links: []dashboardLink{
+			link("png", "requests.png", "w=800&h=200"), link("svg", "requests.svg", "w=800&h=200"),
+		}},
+		{ID: "latency", Title: "Request Latency", Height: 200, Links: []dashboardLink{
+			link("png", "latency.png", "w=800&h=200&p=50,90,99"), link("svg", "latency.svg", "w=800&h=200&p=50,90,99"),
+		}},
+		{ID: "bytes", Title: "Throughput", Height: 200, Links: []dashboardLink{
+			link("png", "bytes.png", "w=800&h=200"), link("svg", "bytes.svg", "w=800&h=200"),
+		}},
+		{ID: "status_codes", Title: "Status Codes", Height: 200, Links: []dashboardLink{
+			link("png", "status.png", "w=800&h=200"), link("by code", "status.svg", "w=800&h=200&by=code"),
+		}},
+		{ID: "errors", Title: "Error Rate", Height: 200, Links: []dashboardLink{
+			link("png", "errors.png", "w=800&h=200"), link("svg", "errors.svg", "w=800&h=200"),
+		}},
+		{ID: "heatmap", Title: "Latency Heatmap", Height: 300, Links: []dashboardLink{
+			link("png", "heatmap.png", "w=800&h=300"), link("svg", "heatmap.svg", "w=800&h=300"),
+		}},
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.Execute(w, data); err != nil {
+		log.Printf("httpstat: error rendering dashboard: %s", err)
+	}
+}
+
+// formatUptime formats secs using the largest two units, e.g. "3d 4h".
+func formatUptime(secs int64) string {
+	dur := time.Duration(secs) * time.Second
+
+	units := []struct {
+		suffix string
+		size   time.Duration
+	}{
+		{"d", 24 * time.Hour},
+		{"h", time.Hour},
+		{"m", time.Minute},
+		{"s", time.Second},
+	}
+
+	var out string
+	var parts int
+	for _, unit := range units {
+		if n := dur / unit.size; n > 0 || (parts == 0 && unit.suffix == "s") {
+			if parts > 0 {
+				out += " "
+			}
+			out += fmt.Sprintf("%d%s", n, unit.suffix)
+			dur -= n * unit.size
+			parts++
+		} else if parts > 0 {
+			break
+		}
+
+		if parts == 2 {
+			break
+		}
+	}
+	return out
+}
@@ -0,0 +1,123 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package statgraph
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestDashboard(t *testing.T) {
+	ts := newTestStats(t)
+	ts.populate(t, 3)
+
+	rec := get(t, New(ts.HTTPStats), "/")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		`id="tile-requests">36<`,
+		`id="tile-error-rate">8.33%<`,
+		`<canvas id="heatmap"`,
+		`src="./assets/dashboard.js"`,
+		`"multiple":false`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("dashboard missing %q", want)
+		}
+	}
+
+	if strings.Contains(body, `id="namespace"`) {
+		t.Error("namespace picker shown for a single namespace")
+	}
+
+	if rec := get(t, New(ts.HTTPStats), "/missing"); rec.Code != http.StatusNotFound {
+		t.Errorf("unexpected status %d for unknown path", rec.Code)
+	}
+}
+
+func TestDashboardNamespaces(t *testing.T) {
+	a, b := newTestStats(t), newTestStats(t)
+	a.populate(t, 2)
+	b.populate(t, 3)
+
+	h := New(a.HTTPStats, b.HTTPStats)
+
+	rec := get(t, h, "/?ns="+url.QueryEscape(b.Namespace()))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		`id="namespace"`,
+		`<option value="` + a.Namespace() + `">`,
+		`<option value="` + b.Namespace() + `" selected>`,
+		`"multiple":true`,
+		`&amp;ns=` + b.Namespace(),
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("dashboard missing %q", want)
+		}
+	}
+
+	// Without ns, the first namespace is used.
+	rec = get(t, h, "/history?fields=requests")
+	var elems []map[string]int64
+	if err := json.Unmarshal(rec.Body.Bytes(), &elems); err != nil || len(elems) != 2 {
+		t.Fatalf("unexpected history for default namespace (%v): %s", err, rec.Body.String())
+	}
+
+	rec = get(t, h, "/history?fields=requests&ns="+url.QueryEscape(b.Namespace()))
+	if err := json.Unmarshal(rec.Body.Bytes(), &elems); err != nil || len(elems) != 3 {
+		t.Fatalf("unexpected history for namespace %q (%v): %s", b.Namespace(), err, rec.Body.String())
+	}
+
+	if rec := get(t, h, "/rps.svg?ns=unknown"); rec.Code != http.StatusNotFound {
+		t.Errorf("unexpected status %d for unknown namespace", rec.Code)
+	}
+}
+
+func TestDashboardAssets(t *testing.T) {
+	ts := newTestStats(t)
+	h := New(ts.HTTPStats)
+
+	for _, tt := range []struct {
+		path        string
+		contentType string
+	}{
+		{"/assets/dashboard.js", "javascript"},
+		{"/assets/dashboard.css", "text/css"},
+	} {
+		rec := get(t, h, tt.path)
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Header().Get("Content-Type"), tt.contentType) {
+			t.Errorf("unexpected response for %s: %d %q", tt.path, rec.Code, rec.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func TestFormatUptime(t *testing.T) {
+	for _, tt := range []struct {
+		secs int64
+		want string
+	}{
+		{0, "0s"},
+		{59, "59s"},
+		{61, "1m 1s"},
+		{3600, "1h"},
+		{3661, "1h 1m"},
+		{90061, "1d 1h"},
+		{86400 + 60, "1d"},
+	} {
+		if got := formatUptime(tt.secs); got != tt.want {
+			t.Errorf("formatUptime(%d) = %q, want %q", tt.secs, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package statgraph
+
+import (
+	"math"
+	"time"
+)
+
+// lttb returns the indices of at most threshold points of (xs, ys), selected
+// using the Largest-Triangle-Three-Buckets algorithm. Unlike averaging, it
+// keeps the visual shape of the series, including any spikes. All indices
+// are returned if there are no more than threshold points.
+func lttb(xs []time.Time, ys []float64, threshold int) []int {
+	n := len(ys)
+	if threshold >= n || threshold < 3 {
+		out := make([]int, n)
+		for i := range out {
+			out[i] = i
+		}
+		return out
+	}
+
+	x := func(i int) float64 { return xs[i].Sub(xs[0]).Seconds() }
+
+	// The first and last points are always kept, and the rest are split into
+	// threshold-2 buckets, keeping the point of each bucket which forms the
+	// largest triangle with the previously kept point and the average of the
+	// next bucket.
+	every := float64(n-2) / float64(threshold-2)
+	out := make([]int, 0, threshold)
+	out = append(out, 0)
+
+	a := 0
+	for i := 0; i < threshold-2; i++ {
+		avgStart := int(float64(i+1)*every) + 1
+		avgEnd := int(float64(i+2)*every) + 1
+		if avgEnd > n {
+			avgEnd = n
+		}
+
+		var avgX, avgY float64
+		for j := avgStart; j < avgEnd; j++ {
+			avgX += x(j)
+			avgY += ys[j]
+		}
+		avgX /= float64(avgEnd - avgStart)
+		avgY /= float64(avgEnd - avgStart)
+
+		start := int(float64(i)*every) + 1
+		end := int(float64(i+1)*every) + 1
+
+		next, maxArea := start, -1.0
+		for j := start; j < end; j++ {
+			area := math.Abs((x(a)-avgX)*(ys[j]-ys[a]) - (x(a)-x(j))*(avgY-ys[a]))
+			if area > maxArea {
+				next, maxArea = j, area
+			}
+		}
+
+		out = append(out, next)
+		a = next
+	}
+
+	return append(out, n-1)
+}
+
+// thin reduces (xs, ys) to at most one point per pixel of width, see lttb.
+func thin(xs []time.Time, ys []float64, width int) ([]time.Time, []float64) {
+	if len(ys) <= width {
+		return xs, ys
+	}
+
+	indices := lttb(xs, ys, width)
+	return pickTimes(xs, indices), pickValues(ys, indices)
+}
+
+func pickTimes(xs []time.Time, indices []int) []time.Time {
+	out := make([]time.Time, len(indices))
+	for i, j := range indices {
+		out[i] = xs[j]
+	}
+	return out
+}
+
+func pickValues(ys []float64, indices []int) []float64 {
+	out := make([]float64, len(indices))
+	for i, j := range indices {
+		out[i] = ys[j]
+	}
+	return out
+}
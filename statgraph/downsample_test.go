@@ -0,0 +1,71 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package statgraph
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lrstanley/httpstat"
+)
+
+func TestLTTB(t *testing.T) {
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	var xs []time.Time
+	var ys []float64
+	for i := 0; i < 1000; i++ {
+		xs = append(xs, start.Add(time.Duration(i)*time.Second))
+		ys = append(ys, float64(i%10))
+	}
+	ys[555] = 500
+
+	indices := lttb(xs, ys, 100)
+	if len(indices) != 100 || indices[0] != 0 || indices[99] != 999 {
+		t.Fatalf("unexpected indices: %v", indices)
+	}
+
+	var spike bool
+	for i := 1; i < len(indices); i++ {
+		if indices[i] <= indices[i-1] {
+			t.Fatalf("indices not ascending: %v", indices)
+		}
+		spike = spike || indices[i] == 555
+	}
+	if !spike {
+		t.Fatal("expected spike to be kept")
+	}
+
+	if got := lttb(xs[:50], ys[:50], 100); len(got) != 50 {
+		t.Fatalf("expected all 50 points to be kept, got %d", len(got))
+	}
+
+	xs, ys = thin(xs, ys, 256)
+	if len(xs) != 256 || len(ys) != 256 {
+		t.Fatalf("expected 256 points, got %d/%d", len(xs), len(ys))
+	}
+}
+
+func TestGraphsDownsampled(t *testing.T) {
+	ts := newTestStats(t, func(opts *httpstat.HistoryOptions) { opts.MaxResolution = time.Hour })
+	for i := 0; i < 300; i++ {
+		ts.serve("/", 1)
+		ts.tick(t)
+	}
+	handler := New(ts.HTTPStats)
+
+	if n := len(ts.History.Elems()); n != 300 {
+		t.Fatalf("expected 300 snapshots, got %d", n)
+	}
+
+	for _, graph := range []string{"rps", "status", "heatmap"} {
+		rec := get(t, handler, "/"+graph+".svg?w=256&h=100")
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "<svg") {
+			t.Fatalf("%s: unexpected response %d: %q", graph, rec.Code, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package statgraph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lrstanley/httpstat"
+)
+
+// eventsPingInterval is how often a comment is sent to idle event streams,
+// so proxies don't time them out.
+var eventsPingInterval = 15 * time.Second
+
+// eventsMeta is sent as the first event of each stream, describing how to
+// interpret the snapshots.
+type eventsMeta struct {
+	Namespace  string `json:"namespace"`
+	Resolution int64  `json:"resolution"` // In milliseconds.
+	Window     int64  `json:"window"`     // In milliseconds.
+	// LatencyBuckets are the upper bounds (in seconds) of each entry in
+	// HistoryElem.LatencyCounts.
+	LatencyBuckets []float64 `json:"latency_buckets"`
+}
+
+// events streams history snapshots as Server-Sent Events. A "meta" event is
+// sent first, followed by a "snapshot" event for each snapshot within the
+// requested window, and then for each new snapshot as it's taken. Each
+// snapshot's id is its birth time (in unix nanoseconds), so reconnecting
+// clients only receive snapshots they haven't seen. Clients which already have
+// some snapshots (e.g. from /history) can pass the birth time of the latest
+// one as ?since= (RFC3339).
+func (rn *renderer) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// Subscribe before reading the backlog, so nothing is missed in between.
+	// Snapshots are dropped for clients which fall too far behind, rather
+	// than blocking the History goroutine.
+	snapshots := make(chan httpstat.HistoryElem, 16)
+	remove := rn.stats.History.OnSnapshot(func(elem httpstat.HistoryElem) {
+		select {
+		case snapshots <- elem:
+		default:
+		}
+	})
+	defer remove()
+
+	var last time.Time
+	if id, err := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64); err == nil {
+		last = time.Unix(0, id)
+	} else if since, err := time.Parse(time.RFC3339Nano, r.FormValue("since")); err == nil {
+		last = since
+	}
+
+	window := rn.stats.History.Opts.MaxResolution
+	if v, err := time.ParseDuration(r.FormValue("window")); err == nil && v > 0 {
+		window = v
+	}
+
+	backlog, ok := rn.elems(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	writeEvent(w, "meta", "", eventsMeta{
+		Namespace:      rn.stats.Namespace(),
+		Resolution:     rn.stats.History.Opts.Resolution.Milliseconds(),
+		Window:         window.Milliseconds(),
+		LatencyBuckets: httpstat.LatencyBuckets(),
+	})
+
+	send := func(elem httpstat.HistoryElem) {
+		if !elem.Born.After(last) {
+			return
+		}
+
+		last = elem.Born
+		writeEvent(w, "snapshot", strconv.FormatInt(elem.Born.UnixNano(), 10), elem)
+	}
+
+	for _, elem := range backlog {
+		send(elem)
+	}
+	flusher.Flush()
+
+	ping := time.NewTicker(eventsPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case elem := <-snapshots:
+			send(elem)
+		case <-ping.C:
+			_, _ = io.WriteString(w, ": ping\n\n")
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w io.Writer, event, id string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+
+	if id != "" {
+		fmt.Fprintf(w, "id: %s\n", id)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+}
@@ -0,0 +1,110 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package statgraph
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lrstanley/httpstat"
+)
+
+type testEvent struct {
+	id, event, data string
+}
+
+func readEvent(t *testing.T, r *bufio.Reader) (ev testEvent) {
+	t.Helper()
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("error reading event: %v", err)
+		}
+
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "":
+			return ev
+		case strings.HasPrefix(line, "id: "):
+			ev.id = line[4:]
+		case strings.HasPrefix(line, "event: "):
+			ev.event = line[7:]
+		case strings.HasPrefix(line, "data: "):
+			ev.data = line[6:]
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	ts := newTestStats(t)
+	ts.populate(t, 3)
+
+	srv := httptest.NewServer(New(ts.HTTPStats))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+
+	r := bufio.NewReader(resp.Body)
+
+	var meta eventsMeta
+	if ev := readEvent(t, r); ev.event != "meta" || json.Unmarshal([]byte(ev.data), &meta) != nil || meta.Resolution != 5000 {
+		t.Fatalf("unexpected meta event: %+v", ev)
+	}
+
+	var lastID string
+	for i := 0; i < 3; i++ {
+		ev := readEvent(t, r)
+
+		var elem httpstat.HistoryElem
+		if ev.event != "snapshot" || json.Unmarshal([]byte(ev.data), &elem) != nil || elem.RequestsDiff != int64(10+i+i%3) {
+			t.Fatalf("unexpected backlog event %d: %+v", i, ev)
+		}
+		lastID = ev.id
+	}
+
+	ts.serve("/", 42)
+	ts.tick(t)
+
+	ev := readEvent(t, r)
+	var elem httpstat.HistoryElem
+	if ev.event != "snapshot" || json.Unmarshal([]byte(ev.data), &elem) != nil || elem.RequestsDiff != 42 {
+		t.Fatalf("unexpected live event: %+v", ev)
+	}
+
+	// Reconnecting with the last seen id should only return newer snapshots.
+	req, _ := http.NewRequest("GET", srv.URL+"/events", nil)
+	req.Header.Set("Last-Event-ID", lastID)
+	resp2, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp2.Body.Close()
+
+	r = bufio.NewReader(resp2.Body)
+	readEvent(t, r)
+	if ev = readEvent(t, r); ev.id <= lastID || !strings.Contains(ev.data, `"RequestsDiff":42`) {
+		t.Fatalf("unexpected event after reconnecting: %+v", ev)
+	}
+
+	born, _ := strconv.ParseInt(ev.id, 10, 64)
+	if !time.Unix(0, born).Equal(elem.Born) {
+		t.Fatalf("expected id to match born time, got %s", ev.id)
+	}
+}
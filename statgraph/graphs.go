@@ -5,9 +5,11 @@
 package statgraph
 
 import (
+	"bytes"
 	"fmt"
 	"math"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -18,83 +20,255 @@ import (
 )
 
 type renderer struct {
-	stats *httpstat.HTTPStats
-	mux   *http.ServeMux
+	stats   *httpstat.HTTPStats
+	mux     *http.ServeMux
+	handler *handler
+}
+
+// handler routes requests to the renderer of the requested namespace.
+type handler struct {
+	renderers []*renderer
+	cache     *renderCache
 }
 
 // New returns a new http handler which allows viewing of httpstat data using
-// png/svg rendered graphs. Note that if history isn't enabled for the
-// requested HTTPStats, New will panic. History must be enabled.
+// png/svg rendered graphs. Note that if history isn't enabled for any of the
+// provided HTTPStats, New will panic. History must be enabled.
+//
+// When multiple HTTPStats are provided, the "ns" query parameter (e.g.
+// ?ns=api) selects which namespace to view, defaulting to the first. Unknown
+// namespaces return a 404.
 //
 // The following endpoints are registered with the return handler:
-//   /{requests,rps,latency}
-//   /{requests,rps,latency}.{svg,png}
+//
+//	/{requests,rps,latency,bytes,status,errors,heatmap}
+//	/{requests,rps,latency,bytes,status,errors,heatmap}.{svg,png}
+//	/{requests,rps,latency,bytes,status,errors}.txt
 //
 // For example the following returns the average latency in svg form:
-//   /latency.svg
 //
-// When viewing the main registered endpoint (/), you can view all three
-// SVG versions of the graphs, which get updated automatically every
-// History.Resolution.
-func New(stats *httpstat.HTTPStats) http.Handler {
-	if !stats.History.Opts.Enabled {
-		panic("cannot create graph handler: requested HTTPStats has history disabled")
-	}
-
-	rn := &renderer{stats: stats, mux: http.NewServeMux()}
-	rn.mux.HandleFunc("/requests", rn.requests)
-	rn.mux.HandleFunc("/requests.svg", rn.requests)
-	rn.mux.HandleFunc("/requests.png", rn.requests)
-	rn.mux.HandleFunc("/rps", rn.requestsPerSecond)
-	rn.mux.HandleFunc("/rps.svg", rn.requestsPerSecond)
-	rn.mux.HandleFunc("/rps.png", rn.requestsPerSecond)
-	rn.mux.HandleFunc("/latency", rn.latency)
-	rn.mux.HandleFunc("/latency.svg", rn.latency)
-	rn.mux.HandleFunc("/latency.png", rn.latency)
-	rn.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path != "/" {
-			http.NotFound(w, r)
+//	/latency.svg
+//
+// /overview.{svg,png} renders the rps, latency, errors and bytes graphs as a
+// grid in a single image, titled with the namespace and uptime, which is handy
+// for pasting into chat or incident docs. Options apply to each graph, while
+// w and h apply to the whole image.
+//
+// The .txt endpoints render the same series as text for terminals, as a
+// multi-line chart, or as a sparkline per series with ?spark=1 (or ?h=1).
+// There, w and h are in characters.
+//
+// The status graph stacks requests by status class (e.g. 2xx), or by
+// individual status code with ?by=code.
+//
+// The latency graph plots the mean latency by default, or the given
+// percentiles (and optionally the mean) as a band chart, e.g. ?p=50,90,99 or
+// ?p=mean,99.
+//
+// The errors graph plots the percentage of requests which errored. With
+// ?threshold=5 (a percentage), a reference line is drawn and points above it
+// are highlighted.
+//
+// The heatmap graph shows the amount of requests within each latency bucket
+// over time, which makes multimodal latency (e.g. cache hits and misses)
+// visible.
+//
+// Rendered graphs are cached until the next snapshot is taken, and include an
+// ETag and Last-Modified header, so clients can cheaply revalidate them.
+//
+// The "window" query parameter (e.g. ?window=6h), or "from" and "to" (see
+// httpstat.ParseHistoryQuery), limits the graph to the given range, and
+// selects the finest History tier which retains it (see HistoryOptions.Tiers).
+// "step" (e.g. ?step=5m) consolidates snapshots into one per step. Lines with
+// more points than pixels are downsampled using LTTB, which keeps spikes.
+//
+// Additionally, /alerts returns the currently pending and firing alerts (see
+// HTTPStats.Alerts) in JSON form, and /history returns the raw history (see
+// History.ServeHTTP).
+//
+// /events streams each new history snapshot as a Server-Sent Event, as it's
+// taken (see History.OnSnapshot).
+//
+// When viewing the main registered endpoint (/), you can view a dashboard with
+// summary tiles and all of the graphs, which are drawn in the browser and
+// update as soon as each snapshot is taken. Dragging across a graph zooms in.
+// The dashboard is self-contained, and doesn't require internet access.
+func New(stats ...*httpstat.HTTPStats) http.Handler {
+	if len(stats) == 0 {
+		panic("cannot create graph handler: no HTTPStats provided")
+	}
+
+	h := &handler{cache: newRenderCache()}
+	for _, s := range stats {
+		if !s.History.Opts.Enabled {
+			panic("cannot create graph handler: requested HTTPStats has history disabled")
+		}
+
+		rn := &renderer{stats: s, mux: http.NewServeMux(), handler: h}
+		rn.mux.HandleFunc("/requests", rn.cached(rn.graph(rn.requests)))
+		rn.mux.HandleFunc("/requests.svg", rn.cached(rn.graph(rn.requests)))
+		rn.mux.HandleFunc("/requests.png", rn.cached(rn.graph(rn.requests)))
+		rn.mux.HandleFunc("/requests.txt", rn.cached(rn.graph(rn.requests)))
+		rn.mux.HandleFunc("/rps", rn.cached(rn.graph(rn.requestsPerSecond)))
+		rn.mux.HandleFunc("/rps.svg", rn.cached(rn.graph(rn.requestsPerSecond)))
+		rn.mux.HandleFunc("/rps.png", rn.cached(rn.graph(rn.requestsPerSecond)))
+		rn.mux.HandleFunc("/rps.txt", rn.cached(rn.graph(rn.requestsPerSecond)))
+		rn.mux.HandleFunc("/latency", rn.cached(rn.graph(rn.latency)))
+		rn.mux.HandleFunc("/latency.svg", rn.cached(rn.graph(rn.latency)))
+		rn.mux.HandleFunc("/latency.png", rn.cached(rn.graph(rn.latency)))
+		rn.mux.HandleFunc("/latency.txt", rn.cached(rn.graph(rn.latency)))
+		rn.mux.HandleFunc("/bytes", rn.cached(rn.graph(rn.bytes)))
+		rn.mux.HandleFunc("/bytes.svg", rn.cached(rn.graph(rn.bytes)))
+		rn.mux.HandleFunc("/bytes.png", rn.cached(rn.graph(rn.bytes)))
+		rn.mux.HandleFunc("/bytes.txt", rn.cached(rn.graph(rn.bytes)))
+		rn.mux.HandleFunc("/status", rn.cached(rn.graph(rn.status)))
+		rn.mux.HandleFunc("/status.svg", rn.cached(rn.graph(rn.status)))
+		rn.mux.HandleFunc("/status.png", rn.cached(rn.graph(rn.status)))
+		rn.mux.HandleFunc("/status.txt", rn.cached(rn.graph(rn.status)))
+		rn.mux.HandleFunc("/errors", rn.cached(rn.graph(rn.errors)))
+		rn.mux.HandleFunc("/errors.svg", rn.cached(rn.graph(rn.errors)))
+		rn.mux.HandleFunc("/errors.png", rn.cached(rn.graph(rn.errors)))
+		rn.mux.HandleFunc("/errors.txt", rn.cached(rn.graph(rn.errors)))
+		rn.mux.HandleFunc("/heatmap", rn.cached(rn.heatmap))
+		rn.mux.HandleFunc("/heatmap.svg", rn.cached(rn.heatmap))
+		rn.mux.HandleFunc("/heatmap.png", rn.cached(rn.heatmap))
+		rn.mux.HandleFunc("/overview", rn.cached(rn.overview))
+		rn.mux.HandleFunc("/overview.svg", rn.cached(rn.overview))
+		rn.mux.HandleFunc("/overview.png", rn.cached(rn.overview))
+		rn.mux.HandleFunc("/alerts", rn.alerts)
+		rn.mux.HandleFunc("/events", rn.events)
+		rn.mux.Handle("/history", &s.History)
+		rn.mux.Handle("/assets/", http.FileServer(http.FS(assets)))
+		rn.mux.HandleFunc("/", rn.dashboard)
+		h.renderers = append(h.renderers, rn)
+	}
+	return h
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, ok := r.URL.Query()["ns"]; !ok {
+		h.renderers[0].ServeHTTP(w, r)
+		return
+	}
+
+	ns := r.URL.Query().Get("ns")
+	for _, rn := range h.renderers {
+		if rn.stats.Namespace() == ns {
+			rn.ServeHTTP(w, r)
 			return
 		}
+	}
 
-		fmt.Fprintf(w, htmlTemplate, int(stats.History.Opts.Resolution.Seconds())*1000)
-	})
-	return rn
+	http.Error(w, fmt.Sprintf("unknown namespace: %q", ns), http.StatusNotFound)
 }
 
 func (rn *renderer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	rn.mux.ServeHTTP(w, r)
 }
 
-func (rn *renderer) latency(w http.ResponseWriter, r *http.Request) {
-	elems := rn.stats.History.Elems()
+// latencyPercentiles are the percentiles which can be requested from the
+// latency graph, in ascending order.
+var latencyPercentiles = []struct {
+	name  string
+	color drawing.Color
+	value func(e *httpstat.HistoryElem) float64
+}{
+	{"50", chart.ColorBlue, func(e *httpstat.HistoryElem) float64 { return e.LatencyP50 }},
+	{"90", chart.ColorOrange, func(e *httpstat.HistoryElem) float64 { return e.LatencyP90 }},
+	{"99", chart.ColorRed, func(e *httpstat.HistoryElem) float64 { return e.LatencyP99 }},
+}
+
+func (rn *renderer) latency(r *http.Request, elems []httpstat.HistoryElem, width int) (chart.Chart, error) {
 	spark := wantsSpark(r)
 
+	// Either the mean (the default), or any of the percentiles, e.g.
+	// ?p=50,90,99 or ?p=mean,99.
+	var wantMean bool
+	var wantPercentiles []int
+	if v := r.FormValue("p"); v != "" {
+		for _, p := range strings.Split(v, ",") {
+			p = strings.TrimPrefix(strings.TrimSpace(p), "p")
+			if p == "mean" {
+				wantMean = true
+				continue
+			}
+
+			found := false
+			for i := range latencyPercentiles {
+				if latencyPercentiles[i].name == p {
+					wantPercentiles = append(wantPercentiles, i)
+					found = true
+				}
+			}
+
+			if !found {
+				return chart.Chart{}, fmt.Errorf("unsupported percentile %q (supported: mean, 50, 90, 99)", p)
+			}
+		}
+		sort.Ints(wantPercentiles)
+	} else {
+		wantMean = true
+	}
+
 	reqTime := []time.Time{}
 	reqLatency := []float64{}
+	percentiles := make([][]float64, len(wantPercentiles))
 	var maxLatency float64
 	for i := 0; i < len(elems); i++ {
 		reqTime = append(reqTime, elems[i].Born)
-		diff := elems[i].TimeDiff / float64(elems[i].RequestsDiff)
-		if math.IsNaN(diff) {
-			diff = 0
+		reqLatency = append(reqLatency, elems[i].LatencyMean)
+		if wantMean {
+			maxLatency = math.Max(maxLatency, elems[i].LatencyMean)
+		}
+
+		for j, p := range wantPercentiles {
+			v := latencyPercentiles[p].value(&elems[i])
+			percentiles[j] = append(percentiles[j], v)
+			maxLatency = math.Max(maxLatency, v)
 		}
-		reqLatency = append(reqLatency, diff)
-		maxLatency = math.Max(maxLatency, diff)
 	}
 
-	ts := chart.TimeSeries{
-		Style: chart.Style{
-			Show:        true,
-			StrokeColor: chart.GetDefaultColor(2),
-			FillColor:   chart.GetAlternateColor(3),
-		},
-		XValues: reqTime,
-		YValues: reqLatency,
+	// Percentiles are drawn from highest to lowest, each filling the area
+	// below it, which leaves a shaded band between each line.
+	series := []chart.Series{}
+	for j := len(wantPercentiles) - 1; j >= 0; j-- {
+		p := latencyPercentiles[wantPercentiles[j]]
+
+		ts := chart.TimeSeries{
+			Name: "p" + p.name,
+			Style: chart.Style{
+				Show:        true,
+				StrokeColor: p.color,
+				FillColor:   lighten(lighten(p.color)),
+			},
+		}
+		ts.XValues, ts.YValues = thin(reqTime, percentiles[j], width)
+
+		if spark {
+			ts.Style.FillColor = drawing.ColorTransparent
+		}
+		series = append(series, ts)
 	}
 
-	if spark {
-		ts.Style.FillColor = drawing.ColorTransparent
+	if wantMean {
+		ts := chart.TimeSeries{
+			Name: "mean",
+			Style: chart.Style{
+				Show:        true,
+				StrokeColor: chart.GetDefaultColor(2),
+				FillColor:   chart.GetAlternateColor(3),
+			},
+		}
+		ts.XValues, ts.YValues = thin(reqTime, reqLatency, width)
+
+		if spark || len(wantPercentiles) > 0 {
+			ts.Style.FillColor = drawing.ColorTransparent
+		}
+		if len(wantPercentiles) > 0 {
+			ts.Style.StrokeColor = chart.ColorBlack
+			ts.Style.StrokeDashArray = []float64{5, 5}
+		}
+		series = append(series, ts)
 	}
 
 	var axisRange chart.Range
@@ -107,7 +281,7 @@ func (rn *renderer) latency(w http.ResponseWriter, r *http.Request) {
 			Name:           "time",
 			NameStyle:      chart.Style{Show: !spark},
 			Style:          chart.Style{Show: !spark},
-			ValueFormatter: chart.TimeValueFormatterWithFormat("15:04:05"),
+			ValueFormatter: timeFormatter(elems),
 		},
 		YAxis: chart.YAxis{
 			Name:      "req time",
@@ -125,19 +299,20 @@ func (rn *renderer) latency(w http.ResponseWriter, r *http.Request) {
 			},
 			Range: axisRange,
 		},
-		Series: []chart.Series{ts},
+		Series: series,
 	}
 
 	if spark {
 		graph.Background.FillColor = drawing.ColorTransparent
 		graph.Canvas.FillColor = drawing.ColorTransparent
+	} else if len(series) > 1 {
+		graph.Elements = []chart.Renderable{chart.Legend(&graph)}
 	}
 
-	renderGraph(w, r, graph)
+	return graph, nil
 }
 
-func (rn *renderer) requestsPerSecond(w http.ResponseWriter, r *http.Request) {
-	elems := rn.stats.History.Elems()
+func (rn *renderer) requestsPerSecond(r *http.Request, elems []httpstat.HistoryElem, width int) (chart.Chart, error) {
 	spark := wantsSpark(r)
 
 	reqTime := []time.Time{}
@@ -156,9 +331,8 @@ func (rn *renderer) requestsPerSecond(w http.ResponseWriter, r *http.Request) {
 			StrokeColor: chart.GetDefaultColor(0),
 			FillColor:   chart.GetAlternateColor(0),
 		},
-		XValues: reqTime,
-		YValues: reqDiff,
 	}
+	ts.XValues, ts.YValues = thin(reqTime, reqDiff, width)
 
 	if spark {
 		ts.Style.FillColor = drawing.ColorTransparent
@@ -174,7 +348,7 @@ func (rn *renderer) requestsPerSecond(w http.ResponseWriter, r *http.Request) {
 			Name:           "time",
 			NameStyle:      chart.Style{Show: !spark},
 			Style:          chart.Style{Show: !spark},
-			ValueFormatter: chart.TimeValueFormatterWithFormat("15:04:05"),
+			ValueFormatter: timeFormatter(elems),
 		},
 		YAxis: chart.YAxis{
 			Name:           "req/sec",
@@ -191,11 +365,10 @@ func (rn *renderer) requestsPerSecond(w http.ResponseWriter, r *http.Request) {
 		graph.Canvas.FillColor = drawing.ColorTransparent
 	}
 
-	renderGraph(w, r, graph)
+	return graph, nil
 }
 
-func (rn *renderer) requests(w http.ResponseWriter, r *http.Request) {
-	elems := rn.stats.History.Elems()
+func (rn *renderer) requests(r *http.Request, elems []httpstat.HistoryElem, width int) (chart.Chart, error) {
 	spark := wantsSpark(r)
 
 	reqTime := []time.Time{}
@@ -214,9 +387,8 @@ func (rn *renderer) requests(w http.ResponseWriter, r *http.Request) {
 			StrokeColor: chart.GetDefaultColor(5),
 			FillColor:   chart.GetAlternateColor(5),
 		},
-		XValues: reqTime,
-		YValues: reqDiff,
 	}
+	ts.XValues, ts.YValues = thin(reqTime, reqDiff, width)
 
 	if spark {
 		ts.Style.FillColor = drawing.ColorTransparent
@@ -232,7 +404,7 @@ func (rn *renderer) requests(w http.ResponseWriter, r *http.Request) {
 			Name:           "time",
 			NameStyle:      chart.Style{Show: !spark},
 			Style:          chart.Style{Show: !spark},
-			ValueFormatter: chart.TimeValueFormatterWithFormat("15:04:05"),
+			ValueFormatter: timeFormatter(elems),
 		},
 		YAxis: chart.YAxis{
 			Name:           "req/ingest",
@@ -249,23 +421,491 @@ func (rn *renderer) requests(w http.ResponseWriter, r *http.Request) {
 		graph.Canvas.FillColor = drawing.ColorTransparent
 	}
 
-	renderGraph(w, r, graph)
+	return graph, nil
+}
+
+func (rn *renderer) bytes(r *http.Request, elems []httpstat.HistoryElem, width int) (chart.Chart, error) {
+	spark := wantsSpark(r)
+
+	reqTime := []time.Time{}
+	bytesIn := []float64{}
+	bytesOut := []float64{}
+	var maxBytes float64
+	for i := 0; i < len(elems); i++ {
+		secs := rn.elapsed(elems, i)
+		in := float64(elems[i].BytesInDiff) / secs
+		out := float64(elems[i].BytesOutDiff) / secs
+
+		reqTime = append(reqTime, elems[i].Born)
+		bytesIn = append(bytesIn, in)
+		bytesOut = append(bytesOut, out)
+		maxBytes = math.Max(maxBytes, math.Max(in, out))
+	}
+
+	inSeries := chart.TimeSeries{
+		Name: "in",
+		Style: chart.Style{
+			Show:        true,
+			StrokeColor: chart.ColorBlue,
+			FillColor:   chart.ColorBlue.WithAlpha(64),
+		},
+	}
+	inSeries.XValues, inSeries.YValues = thin(reqTime, bytesIn, width)
+
+	outSeries := chart.TimeSeries{
+		Name: "out",
+		Style: chart.Style{
+			Show:        true,
+			StrokeColor: chart.ColorOrange,
+			FillColor:   chart.ColorOrange.WithAlpha(64),
+		},
+	}
+	outSeries.XValues, outSeries.YValues = thin(reqTime, bytesOut, width)
+
+	if spark {
+		inSeries.Style.FillColor = drawing.ColorTransparent
+		outSeries.Style.FillColor = drawing.ColorTransparent
+	}
+
+	var axisRange chart.Range
+	if wantsZeroBase(r) {
+		axisRange = &chart.ContinuousRange{Min: 0, Max: maxBytes}
+	}
+
+	graph := chart.Chart{
+		XAxis: chart.XAxis{
+			Name:           "time",
+			NameStyle:      chart.Style{Show: !spark},
+			Style:          chart.Style{Show: !spark},
+			ValueFormatter: timeFormatter(elems),
+		},
+		YAxis: chart.YAxis{
+			Name:           "throughput",
+			NameStyle:      chart.Style{Show: !spark},
+			Style:          chart.Style{Show: !spark},
+			ValueFormatter: func(v interface{}) string { return formatBytes(v.(float64)) + "/s" },
+			Range:          axisRange,
+		},
+		Series: []chart.Series{inSeries, outSeries},
+	}
+
+	if spark {
+		graph.Background.FillColor = drawing.ColorTransparent
+		graph.Canvas.FillColor = drawing.ColorTransparent
+	} else {
+		graph.Elements = []chart.Renderable{chart.Legend(&graph)}
+	}
+
+	return graph, nil
+}
+
+func (rn *renderer) errors(r *http.Request, elems []httpstat.HistoryElem, width int) (chart.Chart, error) {
+	spark := wantsSpark(r)
+
+	var threshold float64
+	hasThreshold := r.FormValue("threshold") != ""
+	if hasThreshold {
+		var err error
+		if threshold, err = strconv.ParseFloat(r.FormValue("threshold"), 64); err != nil {
+			return chart.Chart{}, fmt.Errorf("invalid threshold %q", r.FormValue("threshold"))
+		}
+	}
+
+	reqTime := []time.Time{}
+	errRate := []float64{}
+	var maxRate float64
+	for i := 0; i < len(elems); i++ {
+		var rate float64
+		if elems[i].RequestsDiff > 0 {
+			rate = float64(elems[i].ErrorsDiff) / float64(elems[i].RequestsDiff) * 100
+		}
+
+		reqTime = append(reqTime, elems[i].Born)
+		errRate = append(errRate, rate)
+		maxRate = math.Max(maxRate, rate)
+	}
+
+	ts := chart.TimeSeries{
+		Name: "error rate",
+		Style: chart.Style{
+			Show:        true,
+			StrokeColor: chart.ColorRed,
+			FillColor:   chart.ColorRed.WithAlpha(64),
+		},
+	}
+	ts.XValues, ts.YValues = thin(reqTime, errRate, width)
+
+	if spark {
+		ts.Style.FillColor = drawing.ColorTransparent
+	}
+
+	series := []chart.Series{ts}
+	if hasThreshold {
+		// Highlight the points which breach the threshold.
+		ts.Style.DotWidthProvider = func(_, _ chart.Range, _ int, _, y float64) float64 {
+			if y > threshold {
+				return 3
+			}
+			return 0
+		}
+		ts.Style.DotColorProvider = func(_, _ chart.Range, _ int, _, y float64) drawing.Color {
+			if y > threshold {
+				return chart.ColorRed
+			}
+			return drawing.ColorTransparent
+		}
+		series[0] = ts
+
+		if len(reqTime) > 0 {
+			series = append(series, chart.TimeSeries{
+				Name: "threshold",
+				Style: chart.Style{
+					Show:            true,
+					StrokeColor:     chart.ColorBlack,
+					StrokeDashArray: []float64{5, 5},
+				},
+				XValues: []time.Time{reqTime[0], reqTime[len(reqTime)-1]},
+				YValues: []float64{threshold, threshold},
+			})
+		}
+		maxRate = math.Max(maxRate, threshold)
+	}
+
+	var axisRange chart.Range
+	if wantsZeroBase(r) {
+		axisRange = &chart.ContinuousRange{Min: 0, Max: maxRate}
+	}
+
+	graph := chart.Chart{
+		XAxis: chart.XAxis{
+			Name:           "time",
+			NameStyle:      chart.Style{Show: !spark},
+			Style:          chart.Style{Show: !spark},
+			ValueFormatter: timeFormatter(elems),
+		},
+		YAxis: chart.YAxis{
+			Name:           "error rate",
+			NameStyle:      chart.Style{Show: !spark},
+			Style:          chart.Style{Show: !spark},
+			ValueFormatter: func(v interface{}) string { return chart.FloatValueFormatterWithFormat(v, "%.1f%%") },
+			Range:          axisRange,
+		},
+		Series: series,
+	}
+
+	if spark {
+		graph.Background.FillColor = drawing.ColorTransparent
+		graph.Canvas.FillColor = drawing.ColorTransparent
+	}
+
+	return graph, nil
+}
+
+// statusClassColors are the colors used for each status class in the status
+// graph, indexed by class.
+var statusClassColors = []drawing.Color{
+	1: chart.ColorAlternateGray,
+	2: chart.ColorGreen,
+	3: chart.ColorBlue,
+	4: chart.ColorOrange,
+	5: chart.ColorRed,
+}
+
+func statusClassColor(class int) drawing.Color {
+	if class < 1 || class >= len(statusClassColors) {
+		return chart.ColorBlack
+	}
+	return statusClassColors[class]
+}
+
+func (rn *renderer) status(r *http.Request, elems []httpstat.HistoryElem, width int) (chart.Chart, error) {
+	spark := wantsSpark(r)
+	byCode := r.FormValue("by") == "code"
+
+	// Only include the classes (or codes) seen within the requested window.
+	seen := map[int]bool{}
+	for i := 0; i < len(elems); i++ {
+		for code, n := range elems[i].StatusDiff {
+			if n == 0 {
+				continue
+			}
+
+			if byCode {
+				seen[code] = true
+			} else if code/100 >= 1 && code/100 <= 5 {
+				seen[code/100] = true
+			}
+		}
+	}
+
+	keys := make([]int, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+
+	if len(keys) == 0 {
+		keys = append(keys, 2)
+		if byCode {
+			keys[0] = http.StatusOK
+		}
+	}
+
+	// Each series is the cumulative sum of itself and all series below it,
+	// and they're drawn top down, so each area covers the one above it.
+	reqTime := []time.Time{}
+	stacked := make([][]float64, len(keys))
+	var maxTotal float64
+	for i := 0; i < len(elems); i++ {
+		reqTime = append(reqTime, elems[i].Born)
+
+		var total float64
+		for k, key := range keys {
+			if byCode {
+				total += float64(elems[i].StatusDiff[key])
+			} else {
+				total += float64(elems[i].StatusClassDiff(key))
+			}
+			stacked[k] = append(stacked[k], total)
+		}
+		maxTotal = math.Max(maxTotal, total)
+	}
+
+	// All series keep the same points (those which best match the shape of the
+	// total), so the stacked areas line up.
+	indices := lttb(reqTime, stacked[len(keys)-1], width)
+
+	series := []chart.Series{}
+	for k := len(keys) - 1; k >= 0; k-- {
+		name := strconv.Itoa(keys[k]) + "xx"
+		color := statusClassColor(keys[k])
+		if byCode {
+			// Codes use the color of their class, and get darker for each
+			// preceding code within the same class.
+			name = strconv.Itoa(keys[k])
+			color = statusClassColor(keys[k] / 100)
+			for j := k - 1; j >= 0 && keys[j]/100 == keys[k]/100; j-- {
+				color = darken(color)
+			}
+		}
+
+		ts := chart.TimeSeries{
+			Name: name,
+			Style: chart.Style{
+				Show:        true,
+				StrokeColor: color,
+				FillColor:   lighten(color),
+			},
+			XValues: pickTimes(reqTime, indices),
+			YValues: pickValues(stacked[k], indices),
+		}
+
+		if spark {
+			ts.Style.FillColor = drawing.ColorTransparent
+		}
+		series = append(series, ts)
+	}
+
+	graph := chart.Chart{
+		XAxis: chart.XAxis{
+			Name:           "time",
+			NameStyle:      chart.Style{Show: !spark},
+			Style:          chart.Style{Show: !spark},
+			ValueFormatter: timeFormatter(elems),
+		},
+		YAxis: chart.YAxis{
+			Name:           "requests",
+			NameStyle:      chart.Style{Show: !spark},
+			Style:          chart.Style{Show: !spark},
+			ValueFormatter: func(v interface{}) string { return chart.FloatValueFormatterWithFormat(v, "%.0f") },
+			// Stacked areas are misleading unless they start from zero.
+			Range: &chart.ContinuousRange{Min: 0, Max: math.Max(maxTotal, 1)},
+		},
+		Series: series,
+	}
+
+	if spark {
+		graph.Background.FillColor = drawing.ColorTransparent
+		graph.Canvas.FillColor = drawing.ColorTransparent
+	} else {
+		graph.Elements = []chart.Renderable{chart.Legend(&graph)}
+	}
+
+	return graph, nil
+}
+
+// lighten returns an opaque color halfway between c and white.
+func lighten(c drawing.Color) drawing.Color {
+	return drawing.Color{
+		R: uint8((int(c.R) + 255) / 2),
+		G: uint8((int(c.G) + 255) / 2),
+		B: uint8((int(c.B) + 255) / 2),
+		A: 255,
+	}
+}
+
+// darken returns c with each channel reduced by a quarter.
+func darken(c drawing.Color) drawing.Color {
+	return drawing.Color{R: c.R - c.R/4, G: c.G - c.G/4, B: c.B - c.B/4, A: c.A}
+}
+
+// elapsed returns the number of seconds covered by elems[i]. The first
+// snapshot has no predecessor, so its request rate is used instead, falling
+// back to the History resolution.
+func (rn *renderer) elapsed(elems []httpstat.HistoryElem, i int) float64 {
+	if i > 0 {
+		if secs := elems[i].Born.Sub(elems[i-1].Born).Seconds(); secs > 0 {
+			return secs
+		}
+	}
+
+	if elems[i].RPS > 0 {
+		return float64(elems[i].RequestsDiff) / elems[i].RPS
+	}
+
+	return rn.stats.History.Opts.Resolution.Seconds()
+}
+
+// formatBytes formats v (in bytes) using binary units, e.g. "1.5 MiB".
+func formatBytes(v float64) string {
+	const unit = 1024
+	if math.Abs(v) < unit {
+		return fmt.Sprintf("%.0f B", v)
+	}
+
+	exp := 0
+	for n := math.Abs(v) / unit; n >= unit && exp < 4; n /= unit {
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", v/math.Pow(unit, float64(exp+1)), "KMGTP"[exp])
+}
+
+// graphFunc builds a graph of elems, with the options requested by r, where
+// series are thinned to width (in pixels). An error is returned if the options
+// are invalid.
+type graphFunc func(r *http.Request, elems []httpstat.HistoryElem, width int) (chart.Chart, error)
+
+// graph returns a handler which renders the graph built by fn.
+func (rn *renderer) graph(fn graphFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		elems, ok := rn.elems(w, r)
+		if !ok {
+			return
+		}
+
+		// Text charts have a column per point at most, so thin them to the
+		// width of the text, rather than to pixels.
+		width, _ := getDimensions(r)
+		if isText(r) {
+			width, _ = getTextDimensions(r)
+		}
+
+		graph, err := fn(r, elems, width)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		renderGraph(w, r, graph)
+	}
+}
+
+// query parses the range of history requested by r (see
+// httpstat.ParseHistoryQuery). If it's invalid, an error is written to w.
+func (rn *renderer) query(w http.ResponseWriter, r *http.Request) (q httpstat.HistoryQuery, ok bool) {
+	q, err := httpstat.ParseHistoryQuery(r, rn.stats.History.Opts.Clock.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return q, false
+	}
+	return q, true
+}
+
+// elems returns the history snapshots requested by r. If the request is
+// invalid, an error is written to w.
+func (rn *renderer) elems(w http.ResponseWriter, r *http.Request) ([]httpstat.HistoryElem, bool) {
+	q, ok := rn.query(w, r)
+	if !ok {
+		return nil, false
+	}
+	return rn.stats.History.Query(q), true
+}
+
+// timeFormatter formats the x-axis of elems, including the date if they span
+// more than a day.
+func timeFormatter(elems []httpstat.HistoryElem) chart.ValueFormatter {
+	if len(elems) > 1 && elems[len(elems)-1].Born.Sub(elems[0].Born) > 24*time.Hour {
+		return chart.TimeValueFormatterWithFormat("01-02 15:04")
+	}
+	return chart.TimeValueFormatterWithFormat("15:04:05")
+}
+
+// isText reports whether r requests a graph rendered as text.
+func isText(r *http.Request) bool {
+	return strings.HasSuffix(strings.ToLower(r.URL.Path), ".txt")
 }
 
 func renderGraph(w http.ResponseWriter, r *http.Request, graph chart.Chart) {
+	if isText(r) {
+		renderText(w, r, graph)
+		return
+	}
+
 	graph.Width, graph.Height = getDimensions(r)
+	setFlatRange(&graph)
 
+	rp, contentType := chart.PNG, "image/png"
 	if strings.HasSuffix(strings.ToLower(r.URL.Path), ".svg") {
-		w.Header().Set("Content-Type", "image/svg+xml")
-		_ = graph.Render(chart.SVG, w)
+		rp, contentType = chart.SVG, "image/svg+xml"
+	}
+
+	// Rendered to a buffer first, as graphs which can't be rendered (e.g.
+	// series without any range) would otherwise result in a partial image.
+	buf := &bytes.Buffer{}
+	if err := graph.Render(rp, buf); err != nil {
+		http.Error(w, "unable to render graph: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// setFlatRange sets the y-axis range of graph if all of its values are the
+// same (e.g. no latency without any requests), which go-chart is otherwise
+// unable to render.
+func setFlatRange(graph *chart.Chart) {
+	if (graph.YAxis.Range != nil && !graph.YAxis.Range.IsZero()) || len(graph.YAxis.Ticks) > 0 {
 		return
 	}
 
-	w.Header().Set("Content-Type", "image/png")
-	_ = graph.Render(chart.PNG, w)
+	min, max := math.Inf(1), math.Inf(-1)
+	for _, s := range graph.Series {
+		values, ok := s.(chart.ValuesProvider)
+		if !ok {
+			continue
+		}
+
+		for i := 0; i < values.Len(); i++ {
+			_, y := values.GetValues(i)
+			min, max = math.Min(min, y), math.Max(max, y)
+		}
+	}
+
+	if !math.IsInf(min, 1) && flat(min, max) {
+		min, max = flatRange(min)
+		graph.YAxis.Range = &chart.ContinuousRange{Min: min, Max: max}
+	}
 }
 
 func getDimensions(r *http.Request) (width, height int) {
+	return getDimensionsWithDefault(r, 1024, 400)
+}
+
+// getDimensionsWithDefault returns the requested dimensions, or defaultWidth
+// and defaultHeight if none were requested.
+func getDimensionsWithDefault(r *http.Request, defaultWidth, defaultHeight int64) (width, height int) {
 	w, _ := strconv.ParseInt(r.FormValue("w"), 10, 64)
 	if w == 0 {
 		w, _ = strconv.ParseInt(r.FormValue("width"), 10, 64)
@@ -276,8 +916,8 @@ func getDimensions(r *http.Request) (width, height int) {
 	}
 
 	if w == 0 && h == 0 {
-		w = 1024
-		h = 400
+		w = defaultWidth
+		h = defaultHeight
 	}
 
 	if w < 256 {
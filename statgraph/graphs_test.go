@@ -0,0 +1,251 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package statgraph
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lrstanley/httpstat"
+)
+
+// testClock is a httpstat.Clock where snapshots are only taken when tick is
+// called.
+type testClock struct {
+	mu   sync.Mutex
+	now  time.Time
+	next chan time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) After(d time.Duration) <-chan time.Time { return c.next }
+
+type testStats struct {
+	*httpstat.HTTPStats
+	clock     *testClock
+	snapshots chan httpstat.HistoryElem
+	handler   http.Handler
+	requests  int
+}
+
+var testStatsID int
+
+// newTestStats returns HTTPStats with a 5s resolution, and opts applied.
+func newTestStats(t *testing.T, opts ...func(*httpstat.HistoryOptions)) *testStats {
+	t.Helper()
+
+	testStatsID++
+	clock := &testClock{now: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC), next: make(chan time.Time)}
+	histOpts := &httpstat.HistoryOptions{
+		Enabled:    true,
+		Resolution: 5 * time.Second,
+		Clock:      clock,
+	}
+	for _, opt := range opts {
+		opt(histOpts)
+	}
+
+	stats := httpstat.New(fmt.Sprintf("statgraph_%d_%d", time.Now().UnixNano(), testStatsID), histOpts)
+	t.Cleanup(stats.Close)
+
+	ts := &testStats{
+		HTTPStats: stats,
+		clock:     clock,
+		snapshots: make(chan httpstat.HistoryElem, 100),
+	}
+	ts.handler = stats.Record(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Requests take between 1ms and 5ms, by advancing the clock.
+		ts.requests++
+		clock.mu.Lock()
+		clock.now = clock.now.Add(time.Duration(1+ts.requests%5) * time.Millisecond)
+		clock.mu.Unlock()
+
+		if r.URL.Path == "/error" {
+			http.Error(w, "oops", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintln(w, "hello")
+	}))
+	stats.History.OnSnapshot(func(elem httpstat.HistoryElem) { ts.snapshots <- elem })
+	return ts
+}
+
+// serve records n requests to path.
+func (ts *testStats) serve(path string, n int) {
+	for i := 0; i < n; i++ {
+		ts.handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", path, strings.NewReader("body")))
+	}
+}
+
+// tick advances the clock by one resolution and waits for the snapshot.
+func (ts *testStats) tick(t *testing.T) {
+	t.Helper()
+
+	ts.clock.mu.Lock()
+	ts.clock.now = ts.clock.now.Truncate(ts.History.Opts.Resolution).Add(ts.History.Opts.Resolution)
+	now := ts.clock.now
+	ts.clock.mu.Unlock()
+
+	ts.clock.next <- now
+	select {
+	case <-ts.snapshots:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for snapshot")
+	}
+}
+
+// populate takes n snapshots, with traffic (and some errors) in between.
+func (ts *testStats) populate(t *testing.T, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		ts.serve("/", 10+i)
+		ts.serve("/error", i%3)
+		ts.tick(t)
+	}
+}
+
+func get(t *testing.T, h http.Handler, target string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+	return rec
+}
+
+func TestGraphs(t *testing.T) {
+	ts := newTestStats(t)
+	ts.populate(t, 10)
+	handler := New(ts.HTTPStats)
+
+	graphs := []struct {
+		name  string
+		query string
+	}{
+		{name: "requests"},
+		{name: "rps"},
+		{name: "latency"},
+		{name: "latency", query: "p=50,90,99"},
+		{name: "latency", query: "p=mean,p99"},
+		{name: "bytes"},
+		{name: "status"},
+		{name: "status", query: "by=code"},
+		{name: "errors"},
+		{name: "errors", query: "threshold=5"},
+		{name: "heatmap"},
+	}
+
+	for _, graph := range graphs {
+		for _, query := range []string{"", "spark=1", "fromzero=1&w=300&h=150", "window=30s", "from=40s&to=10s", "from=40s&to=10s&step=10s"} {
+			query = strings.Trim(graph.query+"&"+query, "&")
+
+			rec := get(t, handler, "/"+graph.name+".svg?"+query)
+			if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "<svg") {
+				t.Fatalf("%s.svg?%s: unexpected response %d: %q", graph.name, query, rec.Code, rec.Body.String())
+			}
+
+			// PNGs are slow to render with the race detector, and are drawn
+			// the same way as SVGs, so only the defaults are rendered.
+			if query != graph.query {
+				continue
+			}
+
+			rec = get(t, handler, "/"+graph.name+".png?"+query)
+			if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Body.String(), "\x89PNG") {
+				t.Fatalf("%s.png?%s: unexpected response %d", graph.name, query, rec.Code)
+			}
+		}
+	}
+
+	// The amount of points of each line graph follows the requested range.
+	points := func(target string) int {
+		t.Helper()
+
+		n := seriesPoints(get(t, handler, target).Body.String())
+		if n == 0 {
+			t.Fatalf("%s: no series drawn", target)
+		}
+		return n
+	}
+
+	for _, graph := range []string{"requests", "rps", "latency", "bytes", "errors"} {
+		all, window := points("/"+graph+".svg"), points("/"+graph+".svg?window=30s")
+		if window >= all {
+			t.Fatalf("%s: expected fewer points with a window (%d) than without (%d)", graph, window, all)
+		}
+
+		rng, step := points("/"+graph+".svg?from=40s&to=10s"), points("/"+graph+".svg?from=40s&to=10s&step=10s")
+		if step >= rng {
+			t.Fatalf("%s: expected fewer points with a step (%d) than without (%d)", graph, step, rng)
+		}
+	}
+
+	// The test requests take milliseconds, so that's what latency is plotted in.
+	if body := get(t, handler, "/latency.svg").Body.String(); !strings.Contains(body, "ms</text>") {
+		t.Fatalf("expected millisecond latency labels, got %q", body)
+	}
+}
+
+// seriesPoints returns the amount of points of the first series line in svg,
+// i.e. the first unfilled path which isn't part of an axis.
+func seriesPoints(svg string) int {
+	for _, path := range strings.Split(svg, "<path")[1:] {
+		if !strings.Contains(path, "fill:none") || strings.Contains(path, "stroke:rgba(51,51,51,1.0)") {
+			continue
+		}
+
+		d := path[strings.Index(path, `d="`)+3:]
+		d = d[:strings.Index(d, `"`)]
+		return strings.Count(d, "M") + strings.Count(d, "L")
+	}
+	return 0
+}
+
+func TestLatencyInvalidPercentile(t *testing.T) {
+	ts := newTestStats(t)
+	ts.populate(t, 2)
+
+	if rec := get(t, New(ts.HTTPStats), "/latency.svg?p=50,95"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unsupported percentile, got %d", rec.Code)
+	}
+}
+
+func TestGraphsInvalidRange(t *testing.T) {
+	ts := newTestStats(t)
+	ts.populate(t, 2)
+	handler := New(ts.HTTPStats)
+
+	for _, target := range []string{"/rps.svg?from=yesterday", "/heatmap.png?window=-5m", "/status?step=x", "/events?to=soon", "/errors.svg?threshold=five"} {
+		if rec := get(t, handler, target); rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", target, rec.Code)
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := map[float64]string{
+		0:               "0 B",
+		1023:            "1023 B",
+		1536:            "1.5 KiB",
+		5 * 1024 * 1024: "5.0 MiB",
+		3 << 30:         "3.0 GiB",
+	}
+
+	for in, want := range tests {
+		if got := formatBytes(in); got != want {
+			t.Fatalf("formatBytes(%v): expected %q, got %q", in, want, got)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package statgraph
+
+import (
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/lrstanley/httpstat"
+	chart "github.com/wcharczuk/go-chart"
+	"github.com/wcharczuk/go-chart/drawing"
+)
+
+// latencyBuckets are the upper bounds of the latency buckets, see
+// httpstat.LatencyBuckets.
+var latencyBuckets = httpstat.LatencyBuckets()
+
+// heatmap renders the amount of requests within each latency bucket (see
+// httpstat.LatencyBuckets), for each snapshot. As the buckets grow
+// exponentially, the y-axis is effectively a log scale.
+func (rn *renderer) heatmap(w http.ResponseWriter, r *http.Request) {
+	q, ok := rn.query(w, r)
+	if !ok {
+		return
+	}
+	spark := wantsSpark(r)
+	width, height := getDimensions(r)
+
+	// Cells can't be thinned out like a line, so snapshots are consolidated
+	// instead, when there are more than pixels to draw them.
+	elems := rn.stats.History.Query(q)
+	if len(elems) > width {
+		span := elems[len(elems)-1].Born.Sub(elems[0].Born)
+		q.Step = (span / time.Duration(width)).Truncate(time.Second) + time.Second
+		elems = rn.stats.History.Query(q)
+	}
+
+	// Only include the range of buckets which had any requests.
+	lo, hi := len(latencyBuckets)+1, 0
+	var maxCount int64
+	for i := 0; i < len(elems); i++ {
+		for k, count := range elems[i].LatencyCounts {
+			if count == 0 {
+				continue
+			}
+
+			if k < lo {
+				lo = k
+			}
+			if k+1 > hi {
+				hi = k + 1
+			}
+			if count > maxCount {
+				maxCount = count
+			}
+		}
+	}
+
+	if hi == 0 {
+		lo, hi = 0, 10
+	}
+
+	// Keep the rows from getting too tall when there's little variance.
+	for hi-lo < 6 {
+		if lo > 0 {
+			lo--
+		}
+		if hi < len(latencyBuckets)+1 {
+			hi++
+		}
+	}
+
+	// Each row boundary is the upper bound of the bucket below it.
+	step := int(math.Ceil(float64(hi-lo) / (float64(height) / 30)))
+	ticks := []chart.Tick{}
+	for k := lo; k <= hi; k += step {
+		ticks = append(ticks, chart.Tick{Value: float64(k), Label: bucketLabel(k)})
+	}
+	if ticks[len(ticks)-1].Value != float64(hi) {
+		ticks = append(ticks, chart.Tick{Value: float64(hi), Label: bucketLabel(hi)})
+	}
+
+	var start, end time.Time
+	if len(elems) > 0 {
+		start = elems[0].Born.Add(-time.Duration(rn.elapsed(elems, 0) * float64(time.Second)))
+		end = elems[len(elems)-1].Born
+	} else {
+		end = rn.stats.History.Opts.Clock.Now()
+		start = end.Add(-rn.stats.History.Opts.Resolution)
+	}
+	xMin, xMax := float64(start.UnixNano()), float64(end.UnixNano())
+
+	cells := func(cr chart.Renderer, box chart.Box, _ chart.Style) {
+		x := func(t time.Time) int {
+			return box.Left + int(float64(box.Width())*(float64(t.UnixNano())-xMin)/(xMax-xMin))
+		}
+		y := func(k int) int {
+			return box.Bottom - int(float64(box.Height())*float64(k-lo)/float64(hi-lo))
+		}
+
+		for i := 0; i < len(elems); i++ {
+			x0 := x(elems[i].Born.Add(-time.Duration(rn.elapsed(elems, i) * float64(time.Second))))
+			x1 := x(elems[i].Born)
+
+			for k, count := range elems[i].LatencyCounts {
+				if count == 0 || k < lo || k >= hi {
+					continue
+				}
+
+				// Counts are log scaled, otherwise a single busy interval
+				// washes out everything else.
+				color := chart.Viridis(math.Log1p(float64(count)), 0, math.Log1p(float64(maxCount)))
+				chart.Draw.Box(cr, chart.Box{Top: y(k + 1), Left: x0, Right: x1, Bottom: y(k)}, chart.Style{
+					FillColor:   color,
+					StrokeColor: color,
+					StrokeWidth: 1,
+				})
+			}
+		}
+	}
+
+	graph := chart.Chart{
+		XAxis: chart.XAxis{
+			Name:           "time",
+			NameStyle:      chart.Style{Show: !spark},
+			Style:          chart.Style{Show: !spark},
+			ValueFormatter: timeFormatter(elems),
+			Range:          &chart.ContinuousRange{Min: xMin, Max: xMax},
+		},
+		YAxis: chart.YAxis{
+			Name:      "req time",
+			NameStyle: chart.Style{Show: !spark},
+			Style:     chart.Style{Show: !spark},
+			Ticks:     ticks,
+		},
+		// The cells are drawn by the element below. This series only exists
+		// as go-chart requires at least one visible series.
+		Series: []chart.Series{chart.TimeSeries{
+			Style:   chart.Style{Show: true, StrokeColor: chart.ColorTransparent},
+			XValues: []time.Time{start, end},
+			YValues: []float64{float64(lo), float64(hi)},
+		}},
+		Elements: []chart.Renderable{cells},
+	}
+
+	if spark {
+		graph.Background.FillColor = drawing.ColorTransparent
+		graph.Canvas.FillColor = drawing.ColorTransparent
+	}
+
+	renderGraph(w, r, graph)
+}
+
+// bucketLabel returns the label for the lower bound of latency bucket k.
+func bucketLabel(k int) string {
+	switch {
+	case k <= 0:
+		return "0s"
+	case k > len(latencyBuckets):
+		return "+Inf"
+	}
+
+	dur := time.Duration(latencyBuckets[k-1] * float64(time.Second))
+	switch {
+	case dur >= time.Second:
+		dur = dur.Round(10 * time.Millisecond)
+	case dur >= time.Millisecond:
+		dur = dur.Round(10 * time.Microsecond)
+	default:
+		dur = dur.Round(time.Microsecond)
+	}
+	return dur.String()
+}
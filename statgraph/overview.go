@@ -0,0 +1,144 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package statgraph
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	chart "github.com/wcharczuk/go-chart"
+	"github.com/wcharczuk/go-chart/drawing"
+)
+
+// overviewTitleHeight is the height of the title above the overview graphs.
+const overviewTitleHeight = 40
+
+// offsetRenderer draws onto a shared renderer, offset by x and y, so multiple
+// charts can be rendered into a single image. Saving is a no-op, as the
+// shared renderer is saved once all charts have been drawn.
+type offsetRenderer struct {
+	chart.Renderer
+	x, y int
+}
+
+func (o *offsetRenderer) MoveTo(x, y int) { o.Renderer.MoveTo(x+o.x, y+o.y) }
+func (o *offsetRenderer) LineTo(x, y int) { o.Renderer.LineTo(x+o.x, y+o.y) }
+
+func (o *offsetRenderer) QuadCurveTo(cx, cy, x, y int) {
+	o.Renderer.QuadCurveTo(cx+o.x, cy+o.y, x+o.x, y+o.y)
+}
+
+func (o *offsetRenderer) ArcTo(cx, cy int, rx, ry, startAngle, delta float64) {
+	o.Renderer.ArcTo(cx+o.x, cy+o.y, rx, ry, startAngle, delta)
+}
+
+func (o *offsetRenderer) Circle(radius float64, x, y int) { o.Renderer.Circle(radius, x+o.x, y+o.y) }
+func (o *offsetRenderer) Text(body string, x, y int)      { o.Renderer.Text(body, x+o.x, y+o.y) }
+func (o *offsetRenderer) Save(w io.Writer) error          { return nil }
+
+// overview renders the rps, latency, errors and bytes graphs as a grid within
+// a single image, titled with the namespace and uptime. Options (e.g. window
+// or p) apply to each graph, and w/h to the whole image.
+func (rn *renderer) overview(w http.ResponseWriter, r *http.Request) {
+	elems, ok := rn.elems(w, r)
+	if !ok {
+		return
+	}
+
+	graphs := []struct {
+		title string
+		fn    graphFunc
+	}{
+		{"Requests Per Second", rn.requestsPerSecond},
+		{"Request Latency", rn.latency},
+		{"Error Rate", rn.errors},
+		{"Throughput", rn.bytes},
+	}
+
+	width, height := getDimensionsWithDefault(r, 1200, 800)
+	cellWidth, cellHeight := width/2, (height-overviewTitleHeight)/2
+
+	charts := make([]chart.Chart, len(graphs))
+	for i, graph := range graphs {
+		var err error
+		if charts[i], err = graph.fn(r, elems, cellWidth); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		charts[i].Title = graph.title
+		charts[i].TitleStyle = chart.Style{Show: true, FontSize: 11}
+		charts[i].Width, charts[i].Height = cellWidth, cellHeight
+		charts[i].Background.Padding = chart.Box{Top: 30, Left: 10, Right: 10, Bottom: 10}
+		setFlatRange(&charts[i])
+	}
+
+	rp, contentType := chart.PNG, "image/png"
+	if strings.HasSuffix(strings.ToLower(r.URL.Path), ".svg") {
+		rp, contentType = chart.SVG, "image/svg+xml"
+	}
+
+	base, err := rp(width, height)
+	if err != nil {
+		http.Error(w, "unable to render graph: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	font, err := chart.GetDefaultFont()
+	if err != nil {
+		http.Error(w, "unable to render graph: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	chart.Draw.Box(base, chart.Box{Right: width, Bottom: height}, chart.Style{
+		FillColor:   drawing.ColorWhite,
+		StrokeColor: drawing.ColorWhite,
+		StrokeWidth: 1,
+	})
+
+	title := rn.stats.Namespace()
+	if title == "" {
+		title = "httpstat"
+	}
+	uptime, _ := strconv.ParseInt(rn.stats.Uptime.String(), 10, 64)
+	title += " (up " + formatUptime(uptime) + ")"
+
+	base.SetFont(font)
+	base.SetFontColor(chart.ColorBlack)
+	base.SetFontSize(16)
+	titleBox := base.MeasureText(title)
+	base.Text(title, (width-titleBox.Width())/2, (overviewTitleHeight+titleBox.Height())/2)
+
+	// Graphs need at least two snapshots to be rendered, so until then, the
+	// cells are left empty.
+	for i, c := range charts {
+		if len(elems) < 2 {
+			break
+		}
+
+		x, y := (i%2)*cellWidth, overviewTitleHeight+(i/2)*cellHeight
+		offset := func(_, _ int) (chart.Renderer, error) {
+			base.ResetStyle()
+			return &offsetRenderer{Renderer: base, x: x, y: y}, nil
+		}
+
+		if err = c.Render(offset, io.Discard); err != nil {
+			http.Error(w, "unable to render graph: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if err = base.Save(buf); err != nil {
+		http.Error(w, "unable to render graph: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	_, _ = w.Write(buf.Bytes())
+}
@@ -0,0 +1,84 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package statgraph
+
+import (
+	"image/png"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lrstanley/httpstat"
+)
+
+func TestOverview(t *testing.T) {
+	ts := newTestStats(t)
+	ts.populate(t, 10)
+	handler := New(ts.HTTPStats)
+
+	rec := get(t, handler, "/overview.svg?p=50,99")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/svg+xml" {
+		t.Fatalf("unexpected response %d: %q", rec.Code, rec.Body.String())
+	}
+
+	body := rec.Body.String()
+	if n := strings.Count(body, "<svg"); n != 1 {
+		t.Fatalf("expected a single svg, got %d", n)
+	}
+	for _, want := range []string{ts.Namespace() + " (up ", "Requests Per Second", "Request Latency", "Error Rate", "Throughput", ">p99<"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("overview missing %q", want)
+		}
+	}
+
+	rec = get(t, handler, "/overview.png")
+	img, err := png.DecodeConfig(rec.Body)
+	if rec.Code != http.StatusOK || err != nil || img.Width != 1200 || img.Height != 800 {
+		t.Fatalf("unexpected png (%d, %v): %+v", rec.Code, err, img)
+	}
+
+	rec = get(t, handler, "/overview.png?w=600&h=400")
+	if img, err := png.DecodeConfig(rec.Body); err != nil || img.Width != 600 || img.Height != 400 {
+		t.Fatalf("unexpected png size (%v): %+v", err, img)
+	}
+
+	if rec := get(t, handler, "/overview.svg?p=42"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid option, got %d", rec.Code)
+	}
+
+	// Without enough snapshots, there's still an image with the title.
+	few := newTestStats(t)
+	for i := 0; i < 3; i++ {
+		rec = get(t, New(few.HTTPStats), "/overview.svg")
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "(up ") {
+			t.Fatalf("unexpected response with %d snapshots %d: %q", i, rec.Code, rec.Body.String())
+		}
+		few.populate(t, 1)
+	}
+
+	// Idle snapshots are flat, but can still be rendered.
+	idle := newTestStats(t)
+	idle.tick(t)
+	idle.tick(t)
+	if rec = get(t, New(idle.HTTPStats), "/overview.png"); rec.Code != http.StatusOK {
+		t.Fatalf("unexpected response without traffic %d: %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestOverviewDownsampled(t *testing.T) {
+	ts := newTestStats(t, func(opts *httpstat.HistoryOptions) { opts.MaxResolution = time.Hour })
+	for i := 0; i < 400; i++ {
+		ts.serve("/", 1+i%7)
+		ts.tick(t)
+	}
+
+	// Each graph is half the width of the image, so that's what series are
+	// thinned to.
+	rec := get(t, New(ts.HTTPStats), "/overview.svg?w=600&h=400")
+	if n := seriesPoints(rec.Body.String()); n == 0 || n > 300 {
+		t.Fatalf("expected at most 300 points per graph, got %d", n)
+	}
+}
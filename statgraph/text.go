@@ -0,0 +1,316 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package statgraph
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	chart "github.com/wcharczuk/go-chart"
+)
+
+// sparkBlocks are the characters used for sparklines, from lowest to highest.
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// textMarkers are the characters used to plot each series of a text chart.
+var textMarkers = []rune("*+o#x%@")
+
+// textSeries is a series, resampled into one value per column.
+type textSeries struct {
+	name   string
+	values []float64
+	set    []bool
+}
+
+// renderText renders the series of graph as text, either as multi-line chart
+// or (with ?spark=1 or ?h=1) as a sparkline per series. Sizes (w and h) are in
+// characters.
+func renderText(w http.ResponseWriter, r *http.Request, graph chart.Chart) {
+	width, height := getTextDimensions(r)
+	spark := wantsSpark(r) || height == 1
+
+	format := graph.YAxis.ValueFormatter
+	if format == nil {
+		format = chart.FloatValueFormatter
+	}
+
+	buf := &bytes.Buffer{}
+	if spark {
+		writeSparklines(buf, graph, width, format)
+	} else {
+		writeTextChart(buf, graph, width, height, format)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write(buf.Bytes())
+}
+
+func getTextDimensions(r *http.Request) (width, height int) {
+	w, _ := strconv.Atoi(r.FormValue("w"))
+	h, _ := strconv.Atoi(r.FormValue("h"))
+
+	if w <= 0 {
+		w = 80
+	} else if w < 20 {
+		w = 20
+	} else if w > 500 {
+		w = 500
+	}
+
+	if h <= 0 {
+		h = 15
+	} else if h > 100 {
+		h = 100
+	}
+
+	return w, h
+}
+
+// resample returns the time series of graph, each resampled into cols values.
+// Where multiple points fall within a column, the largest is used (so spikes
+// aren't lost), and columns without any points repeat the previous value.
+func resample(graph chart.Chart, cols int) (series []textSeries, start, end time.Time) {
+	var all []chart.TimeSeries
+	for _, s := range graph.Series {
+		ts, ok := s.(chart.TimeSeries)
+		if !ok || len(ts.XValues) == 0 {
+			continue
+		}
+		all = append(all, ts)
+
+		if start.IsZero() || ts.XValues[0].Before(start) {
+			start = ts.XValues[0]
+		}
+		if last := ts.XValues[len(ts.XValues)-1]; last.After(end) {
+			end = last
+		}
+	}
+
+	span := end.Sub(start)
+	for _, ts := range all {
+		out := textSeries{name: ts.Name, values: make([]float64, cols), set: make([]bool, cols)}
+
+		for i, x := range ts.XValues {
+			col := 0
+			if span > 0 {
+				col = int(float64(x.Sub(start)) / float64(span) * float64(cols-1))
+			}
+
+			if !out.set[col] || ts.YValues[i] > out.values[col] {
+				out.values[col] = ts.YValues[i]
+			}
+			out.set[col] = true
+		}
+
+		first, last := -1, -1
+		for col := range out.set {
+			if out.set[col] {
+				if first < 0 {
+					first = col
+				}
+				last = col
+			}
+		}
+		for col := first + 1; first >= 0 && col < last; col++ {
+			if !out.set[col] {
+				out.values[col], out.set[col] = out.values[col-1], true
+			}
+		}
+
+		series = append(series, out)
+	}
+
+	return series, start, end
+}
+
+// dataRange returns the smallest and largest values of series.
+func dataRange(series ...textSeries) (min, max float64, ok bool) {
+	min, max = math.Inf(1), math.Inf(-1)
+	for _, s := range series {
+		for col, v := range s.values {
+			if s.set[col] {
+				min, max, ok = math.Min(min, v), math.Max(max, v), true
+			}
+		}
+	}
+	return min, max, ok
+}
+
+// flat reports whether min and max are equal, ignoring floating point noise.
+func flat(min, max float64) bool {
+	return max-min <= 1e-9*math.Max(math.Abs(min), math.Abs(max))
+}
+
+// flatRange returns the range used for series where all values are v, so
+// they are drawn in the middle.
+func flatRange(v float64) (min, max float64) {
+	if v > 0 {
+		return 0, 2 * v
+	}
+	return v, v + 1
+}
+
+// valueRange returns the range of the y-axis, from the graph if it's explicitly
+// set, or from the values of series. Flat series are drawn in the middle.
+func valueRange(graph chart.Chart, series []textSeries) (min, max float64) {
+	if rng, ok := graph.YAxis.Range.(*chart.ContinuousRange); ok {
+		min, max = rng.Min, rng.Max
+	} else if min, max, ok = dataRange(series...); !ok {
+		return 0, 1
+	}
+
+	if flat(min, max) {
+		return flatRange(min)
+	}
+	return min, max
+}
+
+func writeSparklines(buf *bytes.Buffer, graph chart.Chart, width int, format chart.ValueFormatter) {
+	series, _, _ := resample(graph, width)
+	if len(series) == 0 {
+		fmt.Fprintf(buf, "%s: no data\n", graph.YAxis.Name)
+		return
+	}
+
+	var nameWidth int
+	for i := range series {
+		if series[i].name == "" {
+			series[i].name = graph.YAxis.Name
+		}
+		if n := len([]rune(series[i].name)); n > nameWidth {
+			nameWidth = n
+		}
+	}
+
+	// Unlike charts, each sparkline is scaled to its own values.
+	for _, s := range series {
+		min, max, _ := dataRange(s)
+
+		var line strings.Builder
+		var last float64
+		for col, v := range s.values {
+			if !s.set[col] {
+				line.WriteRune(' ')
+				continue
+			}
+
+			var i int
+			if !flat(min, max) {
+				i = int(math.Round((v - min) / (max - min) * float64(len(sparkBlocks)-1)))
+			}
+			if i < 0 {
+				i = 0
+			} else if i >= len(sparkBlocks) {
+				i = len(sparkBlocks) - 1
+			}
+			line.WriteRune(sparkBlocks[i])
+			last = v
+		}
+
+		fmt.Fprintf(buf, "%-*s %s  min %s  max %s  last %s\n",
+			nameWidth, s.name, line.String(), format(min), format(max), format(last),
+		)
+	}
+}
+
+func writeTextChart(buf *bytes.Buffer, graph chart.Chart, width, height int, format chart.ValueFormatter) {
+	if height < 3 {
+		height = 3
+	}
+
+	// The plot is narrowed by the width of the y-axis labels, which depends on
+	// the range of values, so the series are resampled again once it's known.
+	series, start, end := resample(graph, width)
+	min, max := valueRange(graph, series)
+	labels := map[int]string{0: format(max), height / 2: format(min + (max-min)/2), height - 1: format(min)}
+
+	var labelWidth int
+	for _, label := range labels {
+		if n := len([]rune(label)); n > labelWidth {
+			labelWidth = n
+		}
+	}
+
+	cols := width - labelWidth - 2
+	if cols < 2 {
+		cols = 2
+	}
+	series, start, end = resample(graph, cols)
+
+	heading := graph.YAxis.Name
+	if len(series) > 1 {
+		for i, s := range series {
+			heading += fmt.Sprintf("  %c %s", textMarkers[i%len(textMarkers)], s.name)
+		}
+	}
+	buf.WriteString(strings.TrimSpace(heading) + "\n")
+
+	grid := make([][]rune, height)
+	for row := range grid {
+		grid[row] = []rune(strings.Repeat(" ", cols))
+	}
+
+	rowOf := func(v float64) int {
+		row := int(math.Round((max - v) / (max - min) * float64(height-1)))
+		if row < 0 {
+			return 0
+		} else if row >= height {
+			return height - 1
+		}
+		return row
+	}
+
+	// Series are drawn in order, so later series are drawn on top, like with
+	// rendered graphs. Rows between consecutive points are filled, so each
+	// series is drawn as a continuous line.
+	for i, s := range series {
+		marker := textMarkers[i%len(textMarkers)]
+		prev := -1
+		for col, v := range s.values {
+			if !s.set[col] {
+				prev = -1
+				continue
+			}
+
+			row := rowOf(v)
+			from, to := row, row
+			if prev >= 0 {
+				if prev < row {
+					from = prev + 1
+				} else if prev > row {
+					to = prev - 1
+				}
+			}
+			for y := from; y <= to; y++ {
+				grid[y][col] = marker
+			}
+			prev = row
+		}
+	}
+
+	for row := range grid {
+		fmt.Fprintf(buf, "%*s |%s\n", labelWidth, labels[row], strings.TrimRight(string(grid[row]), " "))
+	}
+	fmt.Fprintf(buf, "%*s +%s\n", labelWidth, "", strings.Repeat("-", cols))
+
+	if len(series) > 0 {
+		xFormat := graph.XAxis.ValueFormatter
+		if xFormat == nil {
+			xFormat = chart.TimeValueFormatter
+		}
+
+		from, to := xFormat(start), xFormat(end)
+		gap := cols - len(from) - len(to)
+		if gap < 1 {
+			gap = 1
+		}
+		fmt.Fprintf(buf, "%*s  %s%s%s\n", labelWidth, "", from, strings.Repeat(" ", gap), to)
+	}
+}
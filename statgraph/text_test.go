@@ -0,0 +1,106 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package statgraph
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/lrstanley/httpstat"
+	chart "github.com/wcharczuk/go-chart"
+)
+
+func TestText(t *testing.T) {
+	ts := newTestStats(t)
+	ts.populate(t, 30)
+	handler := New(ts.HTTPStats)
+
+	rec := get(t, handler, "/rps.txt?w=60&h=10")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("unexpected response %d: %q", rec.Code, rec.Body.String())
+	}
+
+	// Heading, 10 rows, the x-axis, and its labels.
+	lines := strings.Split(strings.TrimSuffix(rec.Body.String(), "\n"), "\n")
+	if len(lines) != 13 || lines[0] != "req/sec" || !strings.HasPrefix(lines[len(lines)-1], "   12:00:05") {
+		t.Fatalf("unexpected chart:\n%s", rec.Body.String())
+	}
+	for _, line := range lines {
+		if n := utf8.RuneCountInString(line); n > 60 {
+			t.Fatalf("line exceeds width (%d): %q", n, line)
+		}
+	}
+
+	// Traffic increases over time, so the first and last points are at the
+	// bottom and top.
+	if !strings.HasSuffix(lines[1], "*") || !strings.Contains(lines[10], "|*") {
+		t.Fatalf("unexpected chart:\n%s", rec.Body.String())
+	}
+
+	rec = get(t, handler, "/latency.txt?p=50,99&h=8")
+	if lines := strings.Split(rec.Body.String(), "\n"); lines[0] != "req time  * p99  + p50" {
+		t.Fatalf("unexpected legend: %q", lines[0])
+	}
+}
+
+func TestTextSparklines(t *testing.T) {
+	ts := newTestStats(t)
+	ts.populate(t, 8)
+	handler := New(ts.HTTPStats)
+
+	rec := get(t, handler, "/bytes.txt?spark=1&w=20")
+	lines := strings.Split(strings.TrimSuffix(rec.Body.String(), "\n"), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "in  ▁") || !strings.HasPrefix(lines[1], "out ▁") {
+		t.Fatalf("unexpected sparklines:\n%s", rec.Body.String())
+	}
+
+	if !strings.Contains(lines[0], "█  min ") {
+		t.Fatalf("expected sparkline to end at its max:\n%s", rec.Body.String())
+	}
+
+	// Height 1 is also a sparkline, and flat series use the lowest block.
+	flat := newTestStats(t)
+	for i := 0; i < 5; i++ {
+		flat.serve("/", 2)
+		flat.tick(t)
+	}
+	rec = get(t, New(flat.HTTPStats), "/rps.txt?h=1&w=20")
+	if want := "req/sec " + strings.Repeat("▁", 20) + "  min "; !strings.HasPrefix(rec.Body.String(), want) {
+		t.Fatalf("unexpected sparkline, want prefix %q:\n%s", want, rec.Body.String())
+	}
+
+	rec = get(t, New(newTestStats(t).HTTPStats), "/rps.txt?spark=1")
+	if rec.Body.String() != "req/sec: no data\n" {
+		t.Fatalf("unexpected output without data: %q", rec.Body.String())
+	}
+}
+
+func TestTextWidth(t *testing.T) {
+	ts := newTestStats(t)
+	ts.populate(t, 3)
+	rn := &renderer{stats: ts.HTTPStats}
+
+	var got int
+	handler := rn.graph(func(r *http.Request, elems []httpstat.HistoryElem, width int) (chart.Chart, error) {
+		got = width
+		return rn.requestsPerSecond(r, elems, width)
+	})
+
+	for target, want := range map[string]int{
+		"/rps.txt?w=40": 40,
+		"/rps.txt":      80,
+		"/rps.png?w=40": 256,
+		"/rps.png":      1024,
+	} {
+		if rec := get(t, handler, target); rec.Code != http.StatusOK {
+			t.Fatalf("%s: unexpected response %d: %q", target, rec.Code, rec.Body.String())
+		}
+		if got != want {
+			t.Fatalf("%s: expected series thinned to %d, got %d", target, want, got)
+		}
+	}
+}
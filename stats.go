@@ -13,6 +13,8 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -20,6 +22,16 @@ import (
 type HTTPStats struct {
 	namespace string
 	closer    chan struct{}
+	clock     Clock
+
+	hookMu sync.RWMutex
+	hooks  []*RequestHook
+
+	// RouteKey, if set, is used to populate RequestEvent.Route, and should
+	// return a low-cardinality identifier for the matched route (e.g. the
+	// route pattern from your router). It is invoked after the request has
+	// been handled.
+	RouteKey func(r *http.Request) string
 
 	PID         *expvar.Int
 	Invoked     *expvar.String
@@ -32,8 +44,15 @@ type HTTPStats struct {
 	BytesInTotal       *expvar.Int
 	BytesOutTotal      *expvar.Int
 	StatusTotal        *expvar.Map
+	InFlight           *expvar.Int
+
+	latency      latencyHistogram
+	inFlightPeak int64
 
 	History History
+	// Alerts evaluates alert rules against each History snapshot. Rules are
+	// only evaluated when History is enabled.
+	Alerts Alerter
 }
 
 // New creates a new middleware http stat recorder. Note that because httpstat
@@ -66,22 +85,28 @@ func New(namespace string, histOpts *HistoryOptions) *HTTPStats {
 		BytesInTotal:       expvar.NewInt("httpstat_" + namespace + "request_bytes_total"),
 		BytesOutTotal:      expvar.NewInt("httpstat_" + namespace + "response_bytes_total"),
 		StatusTotal:        expvar.NewMap("httpstat_" + namespace + "status_total"),
+		InFlight:           expvar.NewInt("httpstat_" + namespace + "requests_in_flight"),
+	}
+
+	if histOpts == nil {
+		histOpts = &HistoryOptions{Enabled: false}
+	}
+
+	s.clock = histOpts.Clock
+	if s.clock == nil {
+		s.clock = systemClock{}
 	}
 
-	started := time.Now()
+	started := s.clock.Now()
 
 	s.PID.Set(int64(os.Getpid()))
 	s.Invoked.Set(started.Format(time.RFC3339))
 	s.InvokedUnix.Set(started.Unix())
 
 	// Custom variables we need to publish ourselves.
-	s.Uptime = &UptimeVar{started: started}
+	s.Uptime = &UptimeVar{started: started, clock: s.clock}
 	expvar.Publish("httpstat_"+namespace+"invoked_seconds", s.Uptime)
 
-	if histOpts == nil {
-		histOpts = &HistoryOptions{Enabled: false}
-	}
-
 	if histOpts.Enabled {
 		if histOpts.MaxResolution < 10*time.Second {
 			histOpts.MaxResolution = 5 * time.Minute
@@ -91,10 +116,21 @@ func New(namespace string, histOpts *HistoryOptions) *HTTPStats {
 		}
 
 		if histOpts.MaxResolution < histOpts.Resolution {
-			histOpts.MaxResolution = time.Duration(histOpts.Resolution.Seconds() * 1.3)
+			histOpts.MaxResolution = time.Duration(float64(histOpts.Resolution) * 1.3)
+		}
+		if histOpts.SaveInterval <= 0 {
+			histOpts.SaveInterval = time.Minute
 		}
 
 		s.History = History{Opts: *histOpts}
+		s.History.Opts.Clock = s.clock
+		s.History.setup()
+		if histOpts.Store != nil {
+			s.History.load()
+		}
+		s.History.OnSnapshot(func(elem HistoryElem) {
+			s.Alerts.evaluate(&s.History, elem.Born)
+		})
 
 		// TODO: use history for averaging?
 		go s.History.watcher(s)
@@ -103,16 +139,33 @@ func New(namespace string, histOpts *HistoryOptions) *HTTPStats {
 	return s
 }
 
+// Namespace returns the namespace the HTTPStats was created with, as it is
+// used in the expvar names (lowercased, without surrounding underscores).
+func (s *HTTPStats) Namespace() string {
+	return strings.TrimSuffix(s.namespace, "_")
+}
+
 // Close is required if using History, it will close the goroutine which
-// manages taking snapshots. Should only be called once.
+// manages taking snapshots, and once it has returned, save the history if
+// HistoryOptions.Store is set. Should only be called once.
 func (s *HTTPStats) Close() {
 	close(s.closer)
+
+	if !s.History.Opts.Enabled {
+		return
+	}
+
+	<-s.History.done
+	if s.History.Opts.Store != nil {
+		s.History.save()
+	}
 }
 
 func (s *HTTPStats) update(r ResponseWriter, dur time.Duration, reqSize int) {
 	statusKey := strconv.FormatInt(int64(r.Status()), 10)
 
 	s.TimeTotal.Add(dur.Seconds())
+	s.latency.observe(dur)
 	s.RequestsTotal.Add(1)
 	s.StatusTotal.Add(statusKey, 1)
 
@@ -125,6 +178,26 @@ func (s *HTTPStats) update(r ResponseWriter, dur time.Duration, reqSize int) {
 	s.BytesOutTotal.Add(int64(r.BytesWritten()))
 }
 
+// begin tracks a new in-flight request, and the peak amount of in-flight
+// requests.
+func (s *HTTPStats) begin() {
+	s.InFlight.Add(1)
+	current := s.InFlight.Value()
+
+	for {
+		peak := atomic.LoadInt64(&s.inFlightPeak)
+		if current <= peak || atomic.CompareAndSwapInt64(&s.inFlightPeak, peak, current) {
+			return
+		}
+	}
+}
+
+// resetInFlightPeak returns the peak amount of in-flight requests since the
+// last call, and resets it to the current amount.
+func (s *HTTPStats) resetInFlightPeak() int64 {
+	return atomic.SwapInt64(&s.inFlightPeak, s.InFlight.Value())
+}
+
 // MarshalJSON implements the json.Marshaler interface, allowing all httpstats
 // expvars that match the configured namespace of the current HTTPStats, are
 // returned in JSON form.
@@ -159,10 +232,14 @@ func (s *HTTPStats) MarshalJSON() ([]byte, error) {
 func (s *HTTPStats) Record(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		rr := NewResponseRecorder(w)
-		start := time.Now()
+		s.begin()
+		defer s.InFlight.Add(-1)
+		start := s.clock.Now()
 		next.ServeHTTP(rr, r)
+		dur := s.clock.Now().Sub(start)
 		reqSize := approxRequestSize(r)
-		s.update(rr, time.Since(start), reqSize)
+		s.update(rr, dur, reqSize)
+		s.emit(r, rr, start, dur, reqSize)
 	})
 }
 
@@ -183,10 +260,16 @@ func (s *HTTPStats) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 // the (or time since invokation) of the struct when calling String().
 type UptimeVar struct {
 	started time.Time
+	clock   Clock
 }
 
 // String returns the amount of seconds that UptimeVar has recorded, in integer
 // form.
 func (u *UptimeVar) String() string {
-	return fmt.Sprintf("%d", int(time.Since(u.started).Seconds()))
+	clock := u.clock
+	if clock == nil {
+		clock = systemClock{}
+	}
+
+	return fmt.Sprintf("%d", int(clock.Now().Sub(u.started).Seconds()))
 }
@@ -0,0 +1,333 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+// Package statsd pushes httpstat metrics to a StatsD (or DogStatsD) agent
+// over UDP.
+package statsd
+
+import (
+	"expvar"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lrstanley/httpstat"
+)
+
+// Options are the options used when creating a new Exporter.
+type Options struct {
+	// Addr is the address of the StatsD agent. Defaults to "127.0.0.1:8125".
+	Addr string
+	// Prefix is prepended to all metric names. Defaults to "httpstat." and
+	// the namespace of the HTTPStats (e.g. "httpstat.frontend.").
+	Prefix string
+	// Interval is how often buffered metrics are flushed, and counters (and
+	// the mean and 99th percentile latency, in milliseconds, as the
+	// "request_time_mean" and "request_time_p99" gauges) are pushed. Defaults
+	// to 10 seconds.
+	Interval time.Duration
+	// PerRequest sends a timer, and counters, for each request (batched into
+	// packets until Interval or MaxPacketSize is reached), rather than only
+	// pushing the change in counters each Interval.
+	PerRequest bool
+	// SampleRate is the rate (0-1] at which requests are sampled when
+	// PerRequest is enabled. Defaults to 1.
+	SampleRate float64
+	// MaxPacketSize is the maximum size of a single UDP packet. Defaults to
+	// 1432, which is safe for most networks.
+	MaxPacketSize int
+	// QueueSize is the size of the request event queue when PerRequest is
+	// enabled. See httpstat.HTTPStats.OnRequestAsync.
+	QueueSize int
+	// DogStatsD enables DogStatsD style tags. When enabled, status codes are
+	// sent as a "status" tag rather than as part of the metric name.
+	DogStatsD bool
+	// Tags are additional DogStatsD tags (e.g. "env:prod") added to every
+	// metric. Only used if DogStatsD is enabled.
+	Tags []string
+}
+
+// Exporter pushes metrics from an HTTPStats to a StatsD agent.
+type Exporter struct {
+	stats *httpstat.HTTPStats
+	opts  Options
+	conn  net.Conn
+	hook  *httpstat.RequestHook
+	tags  string
+
+	mu          sync.Mutex
+	buf         []byte
+	last        map[string]int64
+	lastTime    float64
+	lastLatency []int64
+	closer      chan struct{}
+	done        chan struct{}
+}
+
+// New returns a new Exporter, which starts pushing metrics immediately. Make
+// sure Exporter.Close is called when it is no longer needed.
+func New(stats *httpstat.HTTPStats, opts *Options) (*Exporter, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	e := &Exporter{
+		stats:  stats,
+		opts:   *opts,
+		last:   make(map[string]int64),
+		closer: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	if e.opts.Addr == "" {
+		e.opts.Addr = "127.0.0.1:8125"
+	}
+	if e.opts.Prefix == "" {
+		e.opts.Prefix = "httpstat."
+		if ns := stats.Namespace(); ns != "" {
+			e.opts.Prefix += ns + "."
+		}
+	}
+	if e.opts.Interval <= 0 {
+		e.opts.Interval = 10 * time.Second
+	}
+	if e.opts.SampleRate <= 0 || e.opts.SampleRate > 1 {
+		e.opts.SampleRate = 1
+	}
+	if e.opts.MaxPacketSize <= 0 {
+		e.opts.MaxPacketSize = 1432
+	}
+	if e.opts.DogStatsD && len(e.opts.Tags) > 0 {
+		e.tags = strings.Join(e.opts.Tags, ",")
+	}
+
+	var err error
+	e.conn, err = net.Dial("udp", e.opts.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.opts.PerRequest {
+		e.hook = stats.OnRequestAsync(e.record, e.opts.QueueSize)
+	} else {
+		// Counters are sent as the change since the previous push, so start
+		// from the current values.
+		e.counters()
+		e.latency()
+	}
+
+	go e.run()
+	return e, nil
+}
+
+func (e *Exporter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.closer:
+			return
+		case <-ticker.C:
+			_ = e.Flush()
+		}
+	}
+}
+
+// Flush pushes the current counters (if PerRequest is disabled) and gauges,
+// and sends any buffered metrics. Errors don't stop the remaining metrics from
+// being sent, as the counter deltas can't be recovered, and the first error
+// is returned.
+func (e *Exporter) Flush() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var firstErr error
+	keep := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if !e.opts.PerRequest {
+		for _, c := range e.counters() {
+			keep(e.add(c.name, strconv.FormatInt(c.value, 10), "c", 1, c.tag))
+		}
+
+		if mean, p99, ok := e.latency(); ok {
+			keep(e.add("request_time_mean", formatMillis(mean), "g", 1, ""))
+			keep(e.add("request_time_p99", formatMillis(p99), "g", 1, ""))
+		}
+	}
+
+	keep(e.add("uptime", e.stats.Uptime.String(), "g", 1, ""))
+	keep(e.flush())
+	return firstErr
+}
+
+// Close stops pushing metrics, and flushes any buffered metrics.
+func (e *Exporter) Close() error {
+	if e.hook != nil {
+		e.hook.Remove()
+	}
+
+	close(e.closer)
+	<-e.done
+
+	err := e.Flush()
+	if cerr := e.conn.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+type counter struct {
+	name  string
+	tag   string
+	value int64
+}
+
+// counters returns the change in each counter since the last call. Must be
+// called with mu held, or before the Exporter is started.
+func (e *Exporter) counters() (out []counter) {
+	current := map[string]*expvar.Int{
+		"requests":       e.stats.RequestsTotal,
+		"request_errors": e.stats.RequestErrorsTotal,
+		"bytes_in":       e.stats.BytesInTotal,
+		"bytes_out":      e.stats.BytesOutTotal,
+	}
+
+	for name, v := range current {
+		value := v.Value()
+		if diff := value - e.last[name]; diff > 0 {
+			out = append(out, counter{name: name, value: diff})
+		}
+		e.last[name] = value
+	}
+
+	e.stats.StatusTotal.Do(func(kv expvar.KeyValue) {
+		value, _ := strconv.ParseInt(kv.Value.String(), 10, 64)
+		key := "status." + kv.Key
+		if diff := value - e.last[key]; diff > 0 {
+			name, tag := e.statusMetric(kv.Key)
+			out = append(out, counter{name: name, tag: tag, value: diff})
+		}
+		e.last[key] = value
+	})
+
+	return out
+}
+
+// latency returns the mean and 99th percentile latency (in seconds) of the
+// requests since the last call, if there were any. Must be called with mu
+// held, or before the Exporter is started.
+func (e *Exporter) latency() (mean, p99 float64, ok bool) {
+	total := e.stats.TimeTotal.Value()
+	counts := e.stats.LatencyCounts()
+
+	var requests int64
+	diff := make([]int64, len(counts))
+	for i := range counts {
+		diff[i] = counts[i]
+		if i < len(e.lastLatency) {
+			diff[i] -= e.lastLatency[i]
+		}
+		requests += diff[i]
+	}
+
+	elapsed := total - e.lastTime
+	e.lastTime, e.lastLatency = total, counts
+	if requests <= 0 {
+		return 0, 0, false
+	}
+
+	return elapsed / float64(requests), httpstat.EstimatePercentile(diff, 0.99), true
+}
+
+func formatMillis(seconds float64) string {
+	return strconv.FormatFloat(seconds*1000, 'f', -1, 64)
+}
+
+func (e *Exporter) statusMetric(status string) (name, tag string) {
+	if e.opts.DogStatsD {
+		return "status", "status:" + status
+	}
+	return "status." + status, ""
+}
+
+func (e *Exporter) record(event httpstat.RequestEvent) {
+	if e.opts.SampleRate < 1 && rand.Float64() >= e.opts.SampleRate {
+		return
+	}
+
+	name, tag := e.statusMetric(strconv.Itoa(event.Status))
+	rate := e.opts.SampleRate
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_ = e.add("request_time", formatMillis(event.Duration.Seconds()), "ms", rate, tag)
+	_ = e.add("requests", "1", "c", rate, tag)
+	_ = e.add(name, "1", "c", rate, tag)
+	if event.Status >= 500 {
+		_ = e.add("request_errors", "1", "c", rate, tag)
+	}
+	_ = e.add("bytes_in", strconv.Itoa(event.BytesIn), "c", rate, tag)
+	_ = e.add("bytes_out", strconv.Itoa(event.BytesOut), "c", rate, tag)
+}
+
+// add appends a metric to the buffer, flushing it first if the metric would
+// exceed MaxPacketSize. Must be called with mu held.
+func (e *Exporter) add(name, value, kind string, rate float64, tag string) error {
+	line := make([]byte, 0, 64)
+	line = append(line, e.opts.Prefix...)
+	line = append(line, name...)
+	line = append(line, ':')
+	line = append(line, value...)
+	line = append(line, '|')
+	line = append(line, kind...)
+
+	if rate < 1 {
+		line = append(line, "|@"...)
+		line = strconv.AppendFloat(line, rate, 'f', -1, 64)
+	}
+
+	if e.opts.DogStatsD && (tag != "" || e.tags != "") {
+		line = append(line, "|#"...)
+		line = append(line, e.tags...)
+		if tag != "" && e.tags != "" {
+			line = append(line, ',')
+		}
+		line = append(line, tag...)
+	}
+
+	var err error
+	if len(e.buf) > 0 && len(e.buf)+1+len(line) > e.opts.MaxPacketSize {
+		err = e.flush()
+	}
+
+	if len(e.buf) > 0 {
+		e.buf = append(e.buf, '\n')
+	}
+	e.buf = append(e.buf, line...)
+
+	return err
+}
+
+// flush sends the buffer. Must be called with mu held.
+func (e *Exporter) flush() error {
+	if len(e.buf) == 0 {
+		return nil
+	}
+
+	_, err := e.conn.Write(e.buf)
+	e.buf = e.buf[:0]
+	return err
+}
@@ -0,0 +1,188 @@
+// Copyright (c) Liam Stanley <me@liamstanley.io>. All rights reserved. Use
+// of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package statsd
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lrstanley/httpstat"
+)
+
+func listen(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func readLines(t *testing.T, conn *net.UDPConn) (lines []string) {
+	buf := make([]byte, 65535)
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, err := conn.Read(buf)
+		if err != nil {
+			break
+		}
+		lines = append(lines, strings.Split(string(buf[:n]), "\n")...)
+	}
+
+	sort.Strings(lines)
+	return lines
+}
+
+func newStats(t *testing.T) *httpstat.HTTPStats {
+	stats := httpstat.New("statsd_"+strconv.Itoa(time.Now().Nanosecond()), nil)
+	t.Cleanup(stats.Close)
+	return stats
+}
+
+func serve(stats *httpstat.HTTPStats, status int) {
+	handler := stats.Record(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte("hello"))
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
+
+func TestExporterCounters(t *testing.T) {
+	conn := listen(t)
+	stats := newStats(t)
+	serve(stats, 200) // Before the exporter, shouldn't be counted.
+
+	e, err := New(stats, &Options{Addr: conn.LocalAddr().String(), Prefix: "app.", Interval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	serve(stats, 200)
+	serve(stats, 502)
+	if err = e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := readLines(t, conn)
+	want := []string{"app.bytes_out:10|c", "app.request_errors:1|c", "app.requests:2|c", "app.status.200:1|c", "app.status.502:1|c"}
+	for _, w := range want {
+		if !contains(lines, w) {
+			t.Errorf("missing %q in %q", w, lines)
+		}
+	}
+
+	for _, name := range []string{"app.request_time_mean:", "app.request_time_p99:"} {
+		var found bool
+		for _, line := range lines {
+			found = found || (strings.HasPrefix(line, name) && strings.HasSuffix(line, "|g"))
+		}
+		if !found {
+			t.Errorf("missing %q gauge in %q", name, lines)
+		}
+	}
+
+	// Latency is only sent for intervals with requests.
+	if err = e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range readLines(t, conn) {
+		if strings.HasPrefix(line, "app.request_time") {
+			t.Errorf("unexpected latency without requests: %q", line)
+		}
+	}
+}
+
+// failingConn fails the first write, and passes the rest through.
+type failingConn struct {
+	net.Conn
+	failed bool
+}
+
+func (c *failingConn) Write(b []byte) (int, error) {
+	if !c.failed {
+		c.failed = true
+		return 0, errors.New("write failed")
+	}
+	return c.Conn.Write(b)
+}
+
+func TestExporterFlushError(t *testing.T) {
+	conn := listen(t)
+	stats := newStats(t)
+
+	// Each metric is sent in its own packet.
+	e, err := New(stats, &Options{Addr: conn.LocalAddr().String(), Prefix: "app.", Interval: time.Hour, MaxPacketSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	e.conn = &failingConn{Conn: e.conn}
+
+	serve(stats, 200)
+	if err = e.Flush(); err == nil {
+		t.Fatal("expected error from Flush")
+	}
+
+	// Only the first packet is lost, the rest are still sent.
+	lines := readLines(t, conn)
+	if !contains(lines, "app.status.200:1|c") || !contains(lines, "app.uptime:0|g") || len(lines) != 6 {
+		t.Fatalf("expected remaining metrics to be sent, got %q", lines)
+	}
+}
+
+func TestExporterPerRequestDogStatsD(t *testing.T) {
+	conn := listen(t)
+	stats := newStats(t)
+
+	e, err := New(stats, &Options{
+		Addr:          conn.LocalAddr().String(),
+		Prefix:        "app.",
+		Interval:      time.Hour,
+		PerRequest:    true,
+		DogStatsD:     true,
+		Tags:          []string{"env:test"},
+		MaxPacketSize: 64,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve(stats, 404)
+	// Wait for the async hook to pick up the event.
+	time.Sleep(50 * time.Millisecond)
+	if err = e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := readLines(t, conn)
+	for _, w := range []string{"app.requests:1|c|#env:test,status:404", "app.status:1|c|#env:test,status:404"} {
+		if !contains(lines, w) {
+			t.Errorf("missing %q in %q", w, lines)
+		}
+	}
+
+	for _, line := range lines {
+		if strings.HasPrefix(line, "app.request_time:") && !strings.HasSuffix(line, "|ms|#env:test,status:404") {
+			t.Errorf("unexpected timer %q", line)
+		}
+	}
+}
+
+func contains(lines []string, s string) bool {
+	for _, line := range lines {
+		if line == s {
+			return true
+		}
+	}
+	return false
+}